@@ -0,0 +1,113 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxApplicationNameLength is the length PostgreSQL truncates
+// application_name to, NAMEDATALEN - 1.
+const maxApplicationNameLength = 63
+
+// WithApplicationName tags the sessions of every pool the provider creates
+// with an application_name made of prefix, the database name and the
+// process ID, e.g. "pgdbtemplate/test_db_42/4711", so that connections of
+// a stuck test run can be told apart in pg_stat_activity.
+func WithApplicationName(prefix string) ConnectionOption {
+	pid := os.Getpid()
+	return WithApplicationNameFunc(func(dbName string) string {
+		return fmt.Sprintf("%s/%s/%d", prefix, dbName, pid)
+	})
+}
+
+// WithApplicationNameFunc tags the sessions of every pool the provider
+// creates with the application_name returned by name for its database.
+func WithApplicationNameFunc(name func(dbName string) string) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.applicationName = name
+	}
+}
+
+// applicationNameKey is the context key of an application_name override.
+type applicationNameKey struct{}
+
+// ContextWithApplicationName returns a context whose statements run on
+// sessions tagged with the given application_name, e.g. the name of the
+// running test:
+//
+//	ctx := pgdbtemplatepgx.ContextWithApplicationName(ctx, t.Name())
+//	_, err := conn.ExecContext(ctx, "...")
+//
+// The session is tagged when a connection is acquired from a provider pool
+// with the context and reverts to the pool's application_name when it is
+// next acquired without an override. Names are truncated to the 63 bytes
+// PostgreSQL keeps, and non-ASCII characters are replaced by "?" as the
+// server does.
+func ContextWithApplicationName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, applicationNameKey{}, sessionApplicationName(name))
+}
+
+// sessionApplicationName returns name as PostgreSQL stores it.
+func sessionApplicationName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c < 32 || c > 126 {
+			b[i] = '?'
+		}
+	}
+	if len(b) > maxApplicationNameLength {
+		b = b[:maxApplicationNameLength]
+	}
+	return string(b)
+}
+
+// tagApplicationName sets the application_name of pools of dbName and
+// installs hooks applying overrides from acquiring contexts.
+func (p *ConnectionProvider) tagApplicationName(config *pgxpool.Config, dbName string) {
+	if p.applicationName != nil {
+		config.ConnConfig.RuntimeParams["application_name"] = sessionApplicationName(p.applicationName(dbName))
+	}
+
+	// overridden holds the connections whose application_name
+	// was set from a context.
+	var overridden sync.Map
+
+	beforeAcquire := config.BeforeAcquire
+	config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+		if beforeAcquire != nil && !beforeAcquire(ctx, conn) {
+			return false
+		}
+
+		// A failing connection is discarded by the pool.
+		name, override := ctx.Value(applicationNameKey{}).(string)
+		switch {
+		case override && conn.PgConn().ParameterStatus("application_name") != name:
+			sql := "SET application_name = '" + strings.ReplaceAll(name, "'", "''") + "'"
+			if _, err := execUntraced(ctx, conn, sql); err != nil {
+				return false
+			}
+			overridden.Store(conn, struct{}{})
+		case !override:
+			if _, ok := overridden.LoadAndDelete(conn); ok {
+				if _, err := execUntraced(ctx, conn, "RESET application_name"); err != nil {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	beforeClose := config.BeforeClose
+	config.BeforeClose = func(conn *pgx.Conn) {
+		overridden.Delete(conn)
+		if beforeClose != nil {
+			beforeClose(conn)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// applicationName returns the application_name of the session
+// running a query on conn with ctx.
+func applicationName(c *qt.C, ctx context.Context, conn pgdbtemplate.DatabaseConnection) string {
+	var name string
+	c.Assert(conn.QueryRowContext(ctx, "SELECT current_setting('application_name')").Scan(&name), qt.IsNil)
+	return name
+}
+
+func TestApplicationName(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Tag pools and override per context", func(c *qt.C) {
+		c.Parallel()
+		// A single connection shows that overrides are reverted.
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithApplicationName("pgdbtemplate"),
+			pgdbtemplatepgx.WithMaxConns(1),
+			pgdbtemplatepgx.WithQueryCapture(),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		want := fmt.Sprintf("pgdbtemplate/%s/%d", dbName, os.Getpid())
+		if len(want) > 63 {
+			want = want[:63]
+		}
+		c.Assert(applicationName(c, ctx, conn), qt.Equals, want)
+
+		pgxConn := conn.(*pgdbtemplatepgx.DatabaseConnection)
+		pgxConn.ResetCapturedQueries()
+
+		testCtx := pgdbtemplatepgx.ContextWithApplicationName(ctx, "TestApplicationName/é")
+		c.Assert(applicationName(c, testCtx, conn), qt.Equals, "TestApplicationName/??")
+		c.Assert(applicationName(c, testCtx, conn), qt.Equals, "TestApplicationName/??")
+		longCtx := pgdbtemplatepgx.ContextWithApplicationName(ctx, strings.Repeat("x", 100))
+		c.Assert(applicationName(c, longCtx, conn), qt.Equals, strings.Repeat("x", 63))
+		c.Assert(applicationName(c, ctx, conn), qt.Equals, want)
+
+		// Tagging sessions does not show up in captured queries.
+		c.Assert(pgxConn.CapturedQueries(), qt.HasLen, 4)
+	})
+
+	c.Run("Override without pool tag", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithMaxConns(1))
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		initial := applicationName(c, ctx, conn)
+		testCtx := pgdbtemplatepgx.ContextWithApplicationName(ctx, "my test")
+		c.Assert(applicationName(c, testCtx, conn), qt.Equals, "my test")
+		c.Assert(applicationName(c, ctx, conn), qt.Equals, initial)
+	})
+}
@@ -0,0 +1,176 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// BootstrapConfig lists the extensions and roles that migrations
+// rely on but which may require elevated privileges to create.
+type BootstrapConfig struct {
+	// ConnectionStringFunc returns the connection string used for
+	// bootstrapping the database with the given name, typically one
+	// of a superuser.
+	//
+	// If nil, the connection of the migrations will be used.
+	ConnectionStringFunc func(dbName string) string
+	// Roles are created unless they exist. Roles are cluster-wide,
+	// so they are shared by all databases of the server.
+	Roles []BootstrapRole
+	// Extensions are created in the database, together with the
+	// extensions they require, unless they exist.
+	Extensions []string
+}
+
+// BootstrapRole is a role created by the bootstrap stage.
+type BootstrapRole struct {
+	// Name is the name of the role.
+	Name string
+	// Options are appended to CREATE ROLE verbatim,
+	// e.g. "NOLOGIN" or "LOGIN PASSWORD 'secret'".
+	Options string
+}
+
+// BootstrapBeforeMigrations returns a migration runner that bootstraps
+// the database according to config and then runs runner.
+//
+// Use it as the MigrationRunner of pgdbtemplate.Config, so that the
+// template database, and thus every test database, is bootstrapped:
+//
+//	config := pgdbtemplate.Config{
+//		ConnectionProvider: provider,
+//		MigrationRunner: pgdbtemplatepgx.BootstrapBeforeMigrations(
+//			pgdbtemplatepgx.NewMigrationRunner([]string{"./migrations"}, nil),
+//			pgdbtemplatepgx.BootstrapConfig{
+//				ConnectionStringFunc: superuserConnStringFunc,
+//				Roles:                []pgdbtemplatepgx.BootstrapRole{{Name: "app_user"}},
+//				Extensions:           []string{"pgcrypto", "citext"},
+//			},
+//		),
+//	}
+//
+// Bootstrapping is idempotent. All extensions are checked against
+// pg_available_extensions before anything is created, so that a missing
+// extension package is reported up front.
+func BootstrapBeforeMigrations(runner pgdbtemplate.MigrationRunner, config BootstrapConfig) pgdbtemplate.MigrationRunner {
+	return &bootstrappingMigrationRunner{runner: runner, config: config}
+}
+
+// bootstrappingMigrationRunner bootstraps databases and runs migrations.
+type bootstrappingMigrationRunner struct {
+	runner pgdbtemplate.MigrationRunner
+	config BootstrapConfig
+}
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+func (r *bootstrappingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	if err := r.bootstrap(ctx, conn); err != nil {
+		return fmt.Errorf("failed to bootstrap database: %w", err)
+	}
+	return r.runner.RunMigrations(ctx, conn)
+}
+
+// bootstrap creates the configured roles and extensions in the database
+// of conn, connecting separately if a connection string is configured.
+func (r *bootstrappingMigrationRunner) bootstrap(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	if r.config.ConnectionStringFunc != nil {
+		var dbName string
+		if err := conn.QueryRowContext(ctx, "SELECT current_database()").Scan(&dbName); err != nil {
+			return fmt.Errorf("failed to get database name: %w", err)
+		}
+
+		config, err := pgxpool.ParseConfig(r.config.ConnectionStringFunc(dbName))
+		if err != nil {
+			return fmt.Errorf("failed to parse connection string: %w", err)
+		}
+		config.MaxConns = 1
+		pool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			return fmt.Errorf("failed to create connection pool: %w", err)
+		}
+
+		// Untracked connections close their pool.
+		bootstrapConn := &DatabaseConnection{Pool: pool, dbName: dbName}
+		defer bootstrapConn.Close()
+		conn = bootstrapConn
+	}
+
+	if err := checkExtensionsAvailable(ctx, conn, r.config.Extensions); err != nil {
+		return err
+	}
+	for _, role := range r.config.Roles {
+		if err := createRoleIfNotExists(ctx, conn, role); err != nil {
+			return err
+		}
+	}
+	for _, extension := range r.config.Extensions {
+		query := "CREATE EXTENSION IF NOT EXISTS " + pgx.Identifier{extension}.Sanitize() + " CASCADE"
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to create extension %q: %w", extension, err)
+		}
+	}
+	return nil
+}
+
+// checkExtensionsAvailable returns an error naming the extensions
+// that the server cannot install.
+func checkExtensionsAvailable(ctx context.Context, conn pgdbtemplate.DatabaseConnection, extensions []string) error {
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	var missing []string
+	err := conn.QueryRowContext(ctx, `
+		SELECT coalesce(array_agg(e.name ORDER BY e.ord), '{}')
+		FROM unnest($1::text[]) WITH ORDINALITY AS e(name, ord)
+		WHERE NOT EXISTS (SELECT FROM pg_available_extensions a WHERE a.name = e.name)`,
+		extensions,
+	).Scan(&missing)
+	if err != nil {
+		return fmt.Errorf("failed to check available extensions: %w", err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("extensions not available on the server: %s (install their packages, see pg_available_extensions)",
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// createRoleIfNotExists creates role unless a role with its name exists.
+func createRoleIfNotExists(ctx context.Context, conn pgdbtemplate.DatabaseConnection, role BootstrapRole) error {
+	if role.Name == "" {
+		return fmt.Errorf("role name must not be empty")
+	}
+
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT FROM pg_roles WHERE rolname = $1)", role.Name).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check role %q: %w", role.Name, err)
+	}
+	if exists {
+		return nil
+	}
+
+	query := "CREATE ROLE " + pgx.Identifier{role.Name}.Sanitize()
+	if role.Options != "" {
+		query += " " + role.Options
+	}
+	_, err = conn.ExecContext(ctx, query)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "42710" || pgErr.Code == "23505") {
+		// duplicate_object or unique_violation: created concurrently,
+		// e.g. by the tests of another package.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create role %q: %w", role.Name, err)
+	}
+	return nil
+}
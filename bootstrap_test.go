@@ -0,0 +1,109 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestBootstrapBeforeMigrations(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Create roles and extensions before migrations", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		roleName := fmt.Sprintf("bootstrap_role_%d_%d", time.Now().UnixNano(), os.Getpid())
+		defer func() {
+			_, err := conn.ExecContext(ctx, "DROP OWNED BY "+pgx.Identifier{roleName}.Sanitize())
+			c.Check(err, qt.IsNil)
+			_, err = conn.ExecContext(ctx, "DROP ROLE "+pgx.Identifier{roleName}.Sanitize())
+			c.Check(err, qt.IsNil)
+		}()
+
+		migrations := writeMigrations(c, map[string]string{
+			"001_users.sql": fmt.Sprintf(`
+				CREATE TABLE users (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), email CITEXT NOT NULL);
+				GRANT SELECT ON users TO %s;`, pgx.Identifier{roleName}.Sanitize()),
+		})
+		runner := pgdbtemplatepgx.BootstrapBeforeMigrations(
+			pgdbtemplatepgx.NewMigrationRunner([]string{migrations}, nil),
+			pgdbtemplatepgx.BootstrapConfig{
+				ConnectionStringFunc: testConnectionStringFuncPgx,
+				Roles:                []pgdbtemplatepgx.BootstrapRole{{Name: roleName, Options: "NOLOGIN"}},
+				Extensions:           []string{"pgcrypto", "citext"},
+			},
+		)
+		c.Assert(runner.RunMigrations(ctx, conn), qt.IsNil)
+
+		var canLogin bool
+		c.Assert(conn.QueryRowContext(ctx, "SELECT rolcanlogin FROM pg_roles WHERE rolname = $1", roleName).Scan(&canLogin), qt.IsNil)
+		c.Assert(canLogin, qt.IsFalse)
+
+		_, err := conn.ExecContext(ctx, "INSERT INTO users (email) VALUES ('Alice@Example.com')")
+		c.Assert(err, qt.IsNil)
+		var count int
+		c.Assert(conn.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE email = 'alice@example.com'").Scan(&count), qt.IsNil)
+		c.Assert(count, qt.Equals, 1)
+
+		// Bootstrapping again is a no-op.
+		bootstrapOnly := pgdbtemplatepgx.BootstrapBeforeMigrations(
+			pgdbtemplatepgx.NewMigrationRunner(nil, nil),
+			pgdbtemplatepgx.BootstrapConfig{
+				Roles:      []pgdbtemplatepgx.BootstrapRole{{Name: roleName}},
+				Extensions: []string{"pgcrypto", "citext"},
+			},
+		)
+		c.Assert(bootstrapOnly.RunMigrations(ctx, conn), qt.IsNil)
+	})
+
+	c.Run("Missing extensions are reported before bootstrapping", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		runner := pgdbtemplatepgx.BootstrapBeforeMigrations(
+			pgdbtemplatepgx.NewMigrationRunner(nil, nil),
+			pgdbtemplatepgx.BootstrapConfig{
+				Extensions: []string{"no_such_extension", "pgcrypto", "another_missing_one"},
+			},
+		)
+		err := runner.RunMigrations(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to bootstrap database: extensions not available on the server: no_such_extension, another_missing_one \(.*\)`)
+
+		var installed bool
+		c.Assert(conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT FROM pg_extension WHERE extname = 'pgcrypto')").Scan(&installed), qt.IsNil)
+		c.Assert(installed, qt.IsFalse)
+	})
+
+	c.Run("Role name required", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		runner := pgdbtemplatepgx.BootstrapBeforeMigrations(
+			pgdbtemplatepgx.NewMigrationRunner(nil, nil),
+			pgdbtemplatepgx.BootstrapConfig{Roles: []pgdbtemplatepgx.BootstrapRole{{Options: "NOLOGIN"}}},
+		)
+		c.Assert(runner.RunMigrations(ctx, conn), qt.ErrorMatches, "failed to bootstrap database: role name must not be empty")
+	})
+}
@@ -0,0 +1,151 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+)
+
+// globalCheckpointCounter is a global atomic counter for unique checkpoint
+// database names across all providers.
+var globalCheckpointCounter int64
+
+// CheckpointID identifies a database checkpoint taken by Checkpoint.
+type CheckpointID string
+
+// Checkpoint snapshots the current state of the database behind conn.
+//
+// The snapshot is a new database cloned with the checkpointed database as
+// its template. Cloning requires that nobody is connected to the source,
+// so all provider-owned pools for it are closed for the duration of the
+// call and reopened afterwards. Connections handed out by the provider
+// keep working, but any connection acquired from their pools must be
+// released before calling Checkpoint, which fails otherwise.
+//
+// Checkpoint databases are dropped by Shutdown or ReleaseCheckpoint.
+func (p *ConnectionProvider) Checkpoint(ctx context.Context, conn pgdbtemplate.DatabaseConnection) (CheckpointID, error) {
+	dbName, err := p.ownDatabaseName(conn)
+	if err != nil {
+		return "", err
+	}
+
+	checkpointName := fmt.Sprintf("checkpoint_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalCheckpointCounter, 1))
+	err = p.withDatabaseDrained(ctx, dbName, func(db *drainedDatabase) error {
+		query := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+			pgx.Identifier{checkpointName}.Sanitize(), pgx.Identifier{dbName}.Sanitize())
+		if err := db.exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to create checkpoint database %s: %w", checkpointName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to checkpoint database %q: %w", dbName, err)
+	}
+
+	id := CheckpointID(checkpointName)
+	p.checkpointsMu.Lock()
+	p.checkpoints[id] = dbName
+	p.checkpointsMu.Unlock()
+	return id, nil
+}
+
+// Restore resets the checkpointed database to the state captured by id.
+//
+// The database is dropped and recreated from the checkpoint, which stays
+// available, so a checkpoint can be restored any number of times. As with
+// Checkpoint, provider-owned pools for the database are reopened and Restore
+// fails while connections are acquired from them.
+func (p *ConnectionProvider) Restore(ctx context.Context, id CheckpointID) error {
+	p.checkpointsMu.Lock()
+	dbName, exists := p.checkpoints[id]
+	p.checkpointsMu.Unlock()
+	if !exists {
+		return fmt.Errorf("unknown checkpoint %q", id)
+	}
+
+	err := p.withDatabaseDrained(ctx, dbName, func(db *drainedDatabase) error {
+		dropQuery := fmt.Sprintf("DROP DATABASE %s", pgx.Identifier{dbName}.Sanitize())
+		if err := db.exec(ctx, dropQuery); err != nil {
+			return fmt.Errorf("failed to drop database: %w", err)
+		}
+
+		createQuery := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+			pgx.Identifier{dbName}.Sanitize(), pgx.Identifier{string(id)}.Sanitize())
+		if err := db.exec(ctx, createQuery); err != nil {
+			return fmt.Errorf("failed to recreate database from checkpoint: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore database %q from checkpoint %q: %w", dbName, id, err)
+	}
+	return nil
+}
+
+// ReleaseCheckpoint drops the checkpoint database identified by id.
+func (p *ConnectionProvider) ReleaseCheckpoint(ctx context.Context, id CheckpointID) error {
+	adminConn, err := p.Connect(ctx, p.adminDBName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	return p.dropCheckpoint(ctx, adminConn, id)
+}
+
+// dropCheckpoints drops all checkpoint databases tracked by the provider.
+func (p *ConnectionProvider) dropCheckpoints(ctx context.Context) (errs error) {
+	p.checkpointsMu.Lock()
+	ids := make([]CheckpointID, 0, len(p.checkpoints))
+	for id := range p.checkpoints {
+		ids = append(ids, id)
+	}
+	p.checkpointsMu.Unlock()
+	if len(ids) == 0 {
+		return nil // No checkpoints to clean up.
+	}
+
+	adminConn, err := p.Connect(ctx, p.adminDBName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	for _, id := range ids {
+		if err := p.dropCheckpoint(ctx, adminConn, id); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// dropCheckpoint drops a single checkpoint database and stops tracking it.
+func (p *ConnectionProvider) dropCheckpoint(ctx context.Context, adminConn pgdbtemplate.DatabaseConnection, id CheckpointID) error {
+	dropQuery := fmt.Sprintf("DROP DATABASE IF EXISTS %s", pgx.Identifier{string(id)}.Sanitize())
+	if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+		return fmt.Errorf("failed to drop checkpoint database %q: %w", id, err)
+	}
+
+	p.checkpointsMu.Lock()
+	delete(p.checkpoints, id)
+	p.checkpointsMu.Unlock()
+	return nil
+}
+
+// ownDatabaseName returns the database name of a connection created
+// by this provider.
+func (p *ConnectionProvider) ownDatabaseName(conn pgdbtemplate.DatabaseConnection) (string, error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok || pgxConn.provider != p {
+		return "", fmt.Errorf("connection was not created by this provider")
+	}
+	if pgxConn.dbName == p.adminDBName {
+		return "", fmt.Errorf("admin database %q cannot be used as a source", pgxConn.dbName)
+	}
+	return pgxConn.dbName, nil
+}
@@ -0,0 +1,145 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// createTestDatabase creates a migrated test database through provider
+// and registers its cleanup with c.
+func createTestDatabase(c *qt.C, provider *pgdbtemplatepgx.ConnectionProvider) (pgdbtemplate.DatabaseConnection, string) {
+	ctx := context.Background()
+
+	tm := newTestTemplateManager(c, createTestMigrationRunner(c), withProvider(provider))
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conn, dbName, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	return conn, dbName
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Restore returns to checkpointed state", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		// Expensive setup phase.
+		_, err := conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('setup')")
+		c.Assert(err, qt.IsNil)
+
+		id, err := provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.IsNil)
+
+		for i := 0; i < 3; i++ {
+			// Each subcase mutates the database freely.
+			_, err := conn.ExecContext(ctx, "DELETE FROM test_table")
+			c.Assert(err, qt.IsNil)
+
+			c.Assert(provider.Restore(ctx, id), qt.IsNil)
+
+			// The same connection keeps working after the restore.
+			var count int
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 3)
+		}
+	})
+
+	c.Run("Shutdown drops checkpoint databases", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		conn, _ := createTestDatabase(c, provider)
+
+		id, err := provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(provider.Shutdown(ctx), qt.IsNil)
+
+		adminConn, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(adminConn.Close(), qt.IsNil) }()
+
+		var exists bool
+		err = adminConn.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", string(id),
+		).Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsFalse)
+
+		// The checkpoint is no longer known to the provider.
+		c.Assert(provider.Restore(ctx, id), qt.ErrorMatches, "unknown checkpoint .*")
+	})
+
+	c.Run("ReleaseCheckpoint drops a single checkpoint", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		id, err := provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.IsNil)
+		c.Assert(provider.ReleaseCheckpoint(ctx, id), qt.IsNil)
+		c.Assert(provider.Restore(ctx, id), qt.ErrorMatches, "unknown checkpoint .*")
+	})
+
+	c.Run("Acquired connections are not waited for", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		acquired, err := conn.(*pgdbtemplatepgx.DatabaseConnection).Pool.Acquire(ctx)
+		c.Assert(err, qt.IsNil)
+		_, err = provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `failed to checkpoint database "`+dbName+`": 1 connections to database "`+dbName+`" are still acquired`)
+		acquired.Release()
+
+		_, err = provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Foreign connections are rejected", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+		otherProvider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer otherProvider.Close()
+
+		conn, err := otherProvider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err = provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, "connection was not created by this provider")
+	})
+
+	c.Run("Admin database cannot be checkpointed", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		conn, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err = provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.ErrorMatches, `admin database "postgres" cannot be used as a source`)
+	})
+}
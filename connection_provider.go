@@ -2,19 +2,90 @@ package pgdbtemplatepgx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/andrei-polukhin/pgdbtemplate"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// defaultAdminDBName is the default administrative database name
+// per PostgreSQL conventions.
+const defaultAdminDBName = "postgres"
+
+// poolKey identifies a provider-owned pool.
+//
+// Pools are keyed by database name and, for schema-per-test isolation,
+// by the schema that the pool's search_path is pinned to. Read-only pools
+// emulating replicas are kept apart from the primary pools.
+type poolKey struct {
+	database string
+	schema   string
+	readOnly bool
+}
+
+// isAdminPool reports whether key identifies the pool of the admin
+// database. Schema pools inside the admin database, e.g. those of
+// SchemaManager, hold test data and are not admin pools.
+func (p *ConnectionProvider) isAdminPool(key poolKey) bool {
+	return key.database == p.adminDBName && key.schema == ""
+}
+
 // poolEntry holds a connection pool and its active reference count.
 type poolEntry struct {
 	pool *pgxpool.Pool
 	refs atomic.Int32
+
+	// conns tracks outstanding connections so that they can be pointed
+	// at a fresh pool when the provider reconnects the database.
+	connsMu sync.Mutex
+	conns   map[*DatabaseConnection]struct{}
+}
+
+// newConnection returns a new tracked connection referencing the entry.
+func (e *poolEntry) newConnection(p *ConnectionProvider, key poolKey) *DatabaseConnection {
+	conn := &DatabaseConnection{
+		Pool:     e.pool,
+		provider: p,
+		dbName:   key.database,
+		schema:   key.schema,
+		readOnly: key.readOnly,
+	}
+
+	e.refs.Add(1)
+	e.connsMu.Lock()
+	e.conns[conn] = struct{}{}
+	e.connsMu.Unlock()
+	return conn
+}
+
+// forgetConnection stops tracking conn and reports whether
+// it was tracked by the entry.
+func (e *poolEntry) forgetConnection(conn *DatabaseConnection) bool {
+	e.connsMu.Lock()
+	defer e.connsMu.Unlock()
+
+	if _, tracked := e.conns[conn]; !tracked {
+		return false
+	}
+	delete(e.conns, conn)
+	return true
+}
+
+// replacePool points the entry and all its outstanding connections
+// at pool.
+func (e *poolEntry) replacePool(pool *pgxpool.Pool) {
+	e.connsMu.Lock()
+	defer e.connsMu.Unlock()
+
+	e.pool = pool
+	for conn := range e.conns {
+		conn.Pool = pool
+	}
 }
 
 // ConnectionProvider implements pgdbtemplate.ConnectionProvider
@@ -22,16 +93,57 @@ type poolEntry struct {
 type ConnectionProvider struct {
 	connectionStringFunc func(string) string
 	poolConfig           pgxpool.Config
+	adminDBName          string
 
 	mu    sync.RWMutex
-	pools map[string]*poolEntry
+	pools map[poolKey]*poolEntry
+
+	checkpointsMu sync.Mutex
+	checkpoints   map[CheckpointID]string // Checkpoint database to source database.
+
+	forksMu sync.Mutex
+	forks   map[string]struct{}
+
+	testRolesMu sync.Mutex
+	testRoles   map[string]*TestRole
+
+	backendsMu sync.Mutex
+	backends   map[string]map[uint32]struct{} // Database name to backend PIDs.
+
+	queryCapture bool
+	queryLogsMu  sync.Mutex
+	queryLogs    map[string]*queryLog // Database name to captured queries.
+
+	slowQueries *slowQueryReporter
+
+	timeouts         SessionTimeouts
+	databaseTimeouts map[string]SessionTimeouts
+	timeoutExempt    map[string]struct{}
+
+	applicationName func(dbName string) string
+
+	replicaLag time.Duration
+
+	typeNames    []string
+	typeSchemas  []string
+	typeCachesMu sync.Mutex
+	typeCaches   map[poolKey]*typeCache // Database and schema to loaded types.
 }
 
 // NewConnectionProvider creates a new pgx-based connection provider.
 func NewConnectionProvider(connectionStringFunc func(string) string, opts ...ConnectionOption) *ConnectionProvider {
 	provider := &ConnectionProvider{
 		connectionStringFunc: connectionStringFunc,
-		pools:                make(map[string]*poolEntry),
+		adminDBName:          defaultAdminDBName,
+		pools:                make(map[poolKey]*poolEntry),
+		checkpoints:          make(map[CheckpointID]string),
+		forks:                make(map[string]struct{}),
+		testRoles:            make(map[string]*TestRole),
+		backends:             make(map[string]map[uint32]struct{}),
+		queryLogs:            make(map[string]*queryLog),
+		databaseTimeouts:     make(map[string]SessionTimeouts),
+		timeoutExempt:        make(map[string]struct{}),
+		typeCaches:           make(map[poolKey]*typeCache),
 	}
 
 	for _, opt := range opts {
@@ -42,16 +154,35 @@ func NewConnectionProvider(connectionStringFunc func(string) string, opts ...Con
 
 // Connect implements pgdbtemplate.ConnectionProvider.Connect.
 func (p *ConnectionProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
-	// Check if we already have a pool for this database.
+	conn, err := p.connect(ctx, poolKey{database: databaseName})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ConnectSchema returns a connection to the specified database whose pool
+// has search_path pinned to schemaName.
+//
+// Pools are shared between ConnectSchema calls for the same database and
+// schema, and are independent of the pool returned by Connect for the same
+// database. The schema itself is not created by this method.
+func (p *ConnectionProvider) ConnectSchema(ctx context.Context, databaseName, schemaName string) (*DatabaseConnection, error) {
+	if schemaName == "" {
+		return nil, fmt.Errorf("schema name must not be empty")
+	}
+	return p.connect(ctx, poolKey{database: databaseName, schema: schemaName})
+}
+
+// connect returns a reference-counted connection for the pool
+// identified by key, creating the pool on first use.
+func (p *ConnectionProvider) connect(ctx context.Context, key poolKey) (*DatabaseConnection, error) {
+	// Check if we already have a pool for this key.
 	p.mu.RLock()
-	if entry, exists := p.pools[databaseName]; exists {
-		entry.refs.Add(1)
+	if entry, exists := p.pools[key]; exists {
+		conn := entry.newConnection(p, key)
 		p.mu.RUnlock()
-		return &DatabaseConnection{
-			Pool:     entry.pool,
-			provider: p,
-			dbName:   databaseName,
-		}, nil
+		return conn, nil
 	}
 	p.mu.RUnlock()
 
@@ -60,19 +191,44 @@ func (p *ConnectionProvider) Connect(ctx context.Context, databaseName string) (
 	defer p.mu.Unlock()
 
 	// Double-check after acquiring write lock.
-	if entry, exists := p.pools[databaseName]; exists {
-		entry.refs.Add(1)
-		return &DatabaseConnection{Pool: entry.pool, provider: p, dbName: databaseName}, nil
+	if entry, exists := p.pools[key]; exists {
+		return entry.newConnection(p, key), nil
+	}
+
+	pool, err := p.newPool(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
+	entry := &poolEntry{pool: pool, conns: make(map[*DatabaseConnection]struct{})}
+	p.pools[key] = entry
+	return entry.newConnection(p, key), nil
+}
+
+// newPool creates and pings a new pool for key.
+func (p *ConnectionProvider) newPool(ctx context.Context, key poolKey) (*pgxpool.Pool, error) {
 	// Parse connection string first.
-	connString := p.connectionStringFunc(databaseName)
+	connString := p.connectionStringFunc(key.database)
 	config, err := pgxpool.ParseConfig(connString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse connection string: %w", err)
 	}
 
 	p.applyPoolConfig(config)
+	p.applySessionTimeouts(config, key)
+	p.tagApplicationName(config, key.database)
+	if key.schema != "" {
+		config.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{key.schema}.Sanitize()
+	}
+	if key.readOnly {
+		p.emulateReplica(config)
+	}
+	p.trackPoolBackends(config, key.database)
+	p.registerPoolTypes(config, key)
+	p.capturePoolQueries(config, key.database)
+	if p.slowQueries != nil && !p.isAdminPool(key) {
+		p.slowQueries.tracePool(config, key.database)
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -84,15 +240,7 @@ func (p *ConnectionProvider) Connect(ctx context.Context, databaseName string) (
 		pool.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-
-	entry := &poolEntry{pool: pool}
-	entry.refs.Add(1)
-	p.pools[databaseName] = entry
-	return &DatabaseConnection{
-		Pool:     pool,
-		provider: p,
-		dbName:   databaseName,
-	}, nil
+	return pool, nil
 }
 
 // applyPoolConfig merges user-provided pool options into a parsed config,
@@ -139,6 +287,30 @@ func (p *ConnectionProvider) applyPoolConfig(config *pgxpool.Config) {
 	}
 }
 
+// trackPoolBackends chains pool hooks recording the server process IDs
+// of connections opened for dbName, so that the provider can tell its own
+// backends apart from foreign ones.
+func (p *ConnectionProvider) trackPoolBackends(config *pgxpool.Config, dbName string) {
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+		p.trackBackend(dbName, conn.PgConn().PID())
+		return nil
+	}
+
+	beforeClose := config.BeforeClose
+	config.BeforeClose = func(conn *pgx.Conn) {
+		p.untrackBackend(dbName, conn.PgConn().PID())
+		if beforeClose != nil {
+			beforeClose(conn)
+		}
+	}
+}
+
 // GetNoRowsSentinel implements pgdbtemplate.ConnectionProvider.GetNoRowsSentinel.
 func (*ConnectionProvider) GetNoRowsSentinel() error {
 	return pgx.ErrNoRows
@@ -156,17 +328,72 @@ func (p *ConnectionProvider) Close() {
 	for _, entry := range p.pools {
 		entry.pool.Close()
 	}
-	p.pools = make(map[string]*poolEntry)
+	p.pools = make(map[poolKey]*poolEntry)
+
+	p.queryLogsMu.Lock()
+	p.queryLogs = make(map[string]*queryLog)
+	p.queryLogsMu.Unlock()
+
+	p.typeCachesMu.Lock()
+	p.typeCaches = make(map[poolKey]*typeCache)
+	p.typeCachesMu.Unlock()
+}
+
+// hasPool reports whether the provider holds a pool for dbName.
+// The caller must hold p.mu.
+func (p *ConnectionProvider) hasPool(dbName string) bool {
+	for key := range p.pools {
+		if key.database == dbName {
+			return true
+		}
+	}
+	return false
+}
+
+// Shutdown closes all connection pools and drops all databases created
+// by the provider, such as checkpoints and forks, and its test roles.
+// With WithSlowQueryReport, it also writes the slow query report.
+//
+// Unlike Close, Shutdown talks to the server and should be preferred at
+// the end of a test suite using Checkpoint or Fork.
+func (p *ConnectionProvider) Shutdown(ctx context.Context) error {
+	// Close pools first so that no provider connections
+	// keep the databases being dropped busy.
+	p.Close()
+	defer p.Close()
+
+	var reportErr error
+	if p.slowQueries != nil {
+		reportErr = p.slowQueries.writeReport()
+	}
+	// Test roles go last, as clones may hold privileges granted to them.
+	return errors.Join(p.dropForks(ctx), p.dropCheckpoints(ctx), p.dropTestRoles(ctx), reportErr)
 }
 
 // DatabaseConnection implements pgdbtemplate.DatabaseConnection using pgx.
+//
+// Pool may be replaced by the provider when the database is reconnected,
+// e.g. by Checkpoint or Restore, so it should be read from the connection
+// rather than cached.
 type DatabaseConnection struct {
 	Pool      *pgxpool.Pool
 	provider  *ConnectionProvider
 	dbName    string
+	schema    string
+	readOnly  bool
 	closeOnce sync.Once
 }
 
+// DatabaseName returns the name of the database the connection points to.
+func (c *DatabaseConnection) DatabaseName() string {
+	return c.dbName
+}
+
+// key returns the provider pool key of the connection.
+func (c *DatabaseConnection) key() poolKey {
+	return poolKey{database: c.dbName, schema: c.schema, readOnly: c.readOnly}
+}
+
 // ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
 func (c *DatabaseConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
 	return c.Pool.Exec(ctx, query, args...)
@@ -197,14 +424,19 @@ func (c *DatabaseConnection) Close() error {
 		c.provider.mu.Lock()
 		defer c.provider.mu.Unlock()
 
-		entry, exists := c.provider.pools[c.dbName]
-		if !exists {
-			// Pool was already removed, e.g. by provider.Close().
+		entry, exists := c.provider.pools[c.key()]
+		if !exists || !entry.forgetConnection(c) {
+			// Pool was already removed, e.g. by provider.Close(),
+			// or replaced by a pool this connection does not reference.
 			return
 		}
 		if entry.refs.Add(-1) == 0 {
 			entry.pool.Close()
-			delete(c.provider.pools, c.dbName)
+			delete(c.provider.pools, c.key())
+			if !c.provider.hasPool(c.dbName) {
+				c.provider.forgetQueryLog(c.dbName)
+				c.provider.forgetTypes(c.dbName)
+			}
 		}
 	})
 	return nil
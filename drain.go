@@ -0,0 +1,194 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// objectInUseCode is the SQLSTATE reported when a database cannot be used
+// as a template or dropped because other sessions are connected to it.
+const objectInUseCode = "55006"
+
+// drainedDatabase describes a database whose provider-owned pools
+// have been closed by withDatabaseDrained.
+type drainedDatabase struct {
+	adminConn   pgdbtemplate.DatabaseConnection
+	name        string
+	backendPIDs []uint32
+}
+
+// exec runs query on the admin database.
+//
+// Backends of closed pools may still be shutting down on the server.
+// If the server reports that the drained database is being accessed,
+// exec terminates the provider's own backends, never foreign ones,
+// and retries once.
+func (d *drainedDatabase) exec(ctx context.Context, query string) error {
+	_, err := d.adminConn.ExecContext(ctx, query)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != objectInUseCode || len(d.backendPIDs) == 0 {
+		return err
+	}
+
+	terminateQuery := `
+		SELECT pg_terminate_backend(pid)
+		FROM pg_stat_activity
+		WHERE datname = $1 AND pid = ANY($2)
+	`
+	if _, termErr := d.adminConn.ExecContext(ctx, terminateQuery, d.name, d.backendPIDs); termErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to terminate own backends: %w", termErr))
+	}
+
+	_, err = d.adminConn.ExecContext(ctx, query)
+	return err
+}
+
+// withDatabaseDrained closes all provider-owned pools for dbName, runs fn
+// against the admin database and reopens the pools.
+//
+// Outstanding connections are pointed at the reopened pools. The provider
+// lock is held throughout, so fn must only use the drained database. As
+// closing a pool waits for its acquired connections to be released, which
+// would block the provider meanwhile, withDatabaseDrained fails instead if
+// any are acquired.
+func (p *ConnectionProvider) withDatabaseDrained(ctx context.Context, dbName string, fn func(db *drainedDatabase) error) (err error) {
+	adminConn, err := p.Connect(ctx, p.adminDBName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkReleased(dbName); err != nil {
+		return err
+	}
+
+	db := &drainedDatabase{adminConn: adminConn, name: dbName, backendPIDs: p.backendPIDs(dbName)}
+	var drained []poolKey
+	for key, entry := range p.pools {
+		if key.database != dbName {
+			continue
+		}
+		entry.pool.Close()
+		drained = append(drained, key)
+	}
+
+	err = fn(db)
+
+	// fn may have changed the database, e.g. by restoring it.
+	p.forgetTypes(dbName)
+
+	// Reopen pools even if fn failed, so that outstanding connections
+	// keep working against whatever state the database is in.
+	for _, key := range drained {
+		pool, poolErr := p.newPool(ctx, key)
+		if poolErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to reconnect to database %q: %w", dbName, poolErr))
+			continue
+		}
+		p.pools[key].replacePool(pool)
+	}
+	return err
+}
+
+// dropDatabase closes and forgets all provider-owned pools for dbName
+// and drops the database.
+//
+// Outstanding connections to dbName stop working. It fails if connections
+// are acquired from the pools, as closing them would wait for their release.
+func (p *ConnectionProvider) dropDatabase(ctx context.Context, dbName string) error {
+	adminConn, err := p.Connect(ctx, p.adminDBName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	p.mu.Lock()
+	if err := p.checkReleased(dbName); err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("failed to drop database %q: %w", dbName, err)
+	}
+	db := &drainedDatabase{adminConn: adminConn, name: dbName, backendPIDs: p.backendPIDs(dbName)}
+	var detached []*poolEntry
+	for key, entry := range p.pools {
+		if key.database != dbName {
+			continue
+		}
+		detached = append(detached, entry)
+		delete(p.pools, key)
+	}
+	p.mu.Unlock()
+
+	// Close detached pools outside the lock, so that the provider stays
+	// usable should a connection be acquired in the meantime.
+	for _, entry := range detached {
+		entry.pool.Close()
+	}
+	p.forgetTypes(dbName)
+
+	dropQuery := fmt.Sprintf("DROP DATABASE IF EXISTS %s", pgx.Identifier{dbName}.Sanitize())
+	if err := db.exec(ctx, dropQuery); err != nil {
+		return fmt.Errorf("failed to drop database %q: %w", dbName, err)
+	}
+	return nil
+}
+
+// checkReleased returns an error if connections are acquired from
+// provider-owned pools for dbName. The caller must hold p.mu.
+func (p *ConnectionProvider) checkReleased(dbName string) error {
+	var acquired int32
+	for key, entry := range p.pools {
+		if key.database == dbName {
+			acquired += entry.pool.Stat().AcquiredConns()
+		}
+	}
+	if acquired > 0 {
+		return fmt.Errorf("%d connections to database %q are still acquired", acquired, dbName)
+	}
+	return nil
+}
+
+// trackBackend records the server process ID of a connection
+// opened by a provider-owned pool for dbName.
+func (p *ConnectionProvider) trackBackend(dbName string, pid uint32) {
+	p.backendsMu.Lock()
+	defer p.backendsMu.Unlock()
+
+	pids, exists := p.backends[dbName]
+	if !exists {
+		pids = make(map[uint32]struct{})
+		p.backends[dbName] = pids
+	}
+	pids[pid] = struct{}{}
+}
+
+// untrackBackend forgets a backend recorded by trackBackend.
+func (p *ConnectionProvider) untrackBackend(dbName string, pid uint32) {
+	p.backendsMu.Lock()
+	defer p.backendsMu.Unlock()
+
+	delete(p.backends[dbName], pid)
+	if len(p.backends[dbName]) == 0 {
+		delete(p.backends, dbName)
+	}
+}
+
+// backendPIDs returns the process IDs of live backends opened by
+// provider-owned pools for dbName.
+func (p *ConnectionProvider) backendPIDs(dbName string) []uint32 {
+	p.backendsMu.Lock()
+	defer p.backendsMu.Unlock()
+
+	pids := make([]uint32, 0, len(p.backends[dbName]))
+	for pid := range p.backends[dbName] {
+		pids = append(pids, pid)
+	}
+	return pids
+}
@@ -0,0 +1,223 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// QueryPlan is the plan of a query as returned by EXPLAIN (FORMAT JSON).
+type QueryPlan struct {
+	Plan *PlanNode `json:"Plan"`
+
+	// Set with ExplainAnalyze only, in milliseconds.
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+}
+
+// PlanNode is a node of a query plan. Fields that do not apply
+// to the node type are zero.
+type PlanNode struct {
+	NodeType           string  `json:"Node Type"`
+	ParentRelationship string  `json:"Parent Relationship"`
+	JoinType           string  `json:"Join Type"`
+	Strategy           string  `json:"Strategy"`
+	RelationName       string  `json:"Relation Name"`
+	Schema             string  `json:"Schema"`
+	Alias              string  `json:"Alias"`
+	IndexName          string  `json:"Index Name"`
+	IndexCond          string  `json:"Index Cond"`
+	Filter             string  `json:"Filter"`
+	StartupCost        float64 `json:"Startup Cost"`
+	TotalCost          float64 `json:"Total Cost"`
+	PlanRows           float64 `json:"Plan Rows"`
+	PlanWidth          int     `json:"Plan Width"`
+
+	// Set with ExplainAnalyze only.
+	ActualRows  float64 `json:"Actual Rows"`
+	ActualLoops float64 `json:"Actual Loops"`
+
+	Plans []*PlanNode `json:"Plans"`
+}
+
+// Nodes returns all nodes of the plan, depth first.
+func (p *QueryPlan) Nodes() []*PlanNode {
+	var nodes []*PlanNode
+	var walk func(node *PlanNode)
+	walk = func(node *PlanNode) {
+		nodes = append(nodes, node)
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	if p.Plan != nil {
+		walk(p.Plan)
+	}
+	return nodes
+}
+
+// String renders the plan as an indented tree, similar to the text
+// format of EXPLAIN.
+func (p *QueryPlan) String() string {
+	var b strings.Builder
+	var write func(node *PlanNode, depth int)
+	write = func(node *PlanNode, depth int) {
+		indent := strings.Repeat("  ", depth)
+		if depth > 0 {
+			indent += "-> "
+		}
+		fmt.Fprintf(&b, "%s%s", indent, node.NodeType)
+		if node.IndexName != "" {
+			fmt.Fprintf(&b, " using %s", node.IndexName)
+		}
+		if node.RelationName != "" {
+			fmt.Fprintf(&b, " on %s", node.RelationName)
+			if node.Alias != "" && node.Alias != node.RelationName {
+				fmt.Fprintf(&b, " %s", node.Alias)
+			}
+		}
+		fmt.Fprintf(&b, "  (cost=%.2f..%.2f rows=%.0f width=%d)\n",
+			node.StartupCost, node.TotalCost, node.PlanRows, node.PlanWidth)
+		if node.IndexCond != "" {
+			fmt.Fprintf(&b, "%s      Index Cond: %s\n", strings.Repeat("  ", depth), node.IndexCond)
+		}
+		if node.Filter != "" {
+			fmt.Fprintf(&b, "%s      Filter: %s\n", strings.Repeat("  ", depth), node.Filter)
+		}
+		for _, child := range node.Plans {
+			write(child, depth+1)
+		}
+	}
+	if p.Plan != nil {
+		write(p.Plan, 0)
+	}
+	return b.String()
+}
+
+// ExplainOption configures Explain.
+type ExplainOption func(*explainConfig)
+
+// explainConfig holds the options of Explain.
+type explainConfig struct {
+	analyze  bool
+	settings [][2]string
+}
+
+// ExplainAnalyze executes the query to add actual row counts and times to
+// the plan. The query runs in a transaction that is rolled back, so data
+// modifications are discarded.
+func ExplainAnalyze() ExplainOption {
+	return func(c *explainConfig) {
+		c.analyze = true
+	}
+}
+
+// ExplainSetting sets a planner setting for the query, e.g.
+// ExplainSetting("enable_seqscan", "off") to check that an index is usable
+// on tables too small for the planner to prefer it.
+func ExplainSetting(name, value string) ExplainOption {
+	return func(c *explainConfig) {
+		c.settings = append(c.settings, [2]string{name, value})
+	}
+}
+
+// Explain returns the plan of query with args in the database behind
+// conn, which must be a *DatabaseConnection.
+func Explain(ctx context.Context, conn pgdbtemplate.DatabaseConnection, query string, args []any, opts ...ExplainOption) (*QueryPlan, error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok {
+		return nil, fmt.Errorf("Explain requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+	config := &explainConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	// Settings and ANALYZE side effects are confined
+	// to a transaction that is never committed.
+	tx, err := pgxConn.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, setting := range config.settings {
+		if _, err := tx.Exec(ctx, "SELECT set_config($1, $2, true)", setting[0], setting[1]); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", setting[0], err)
+		}
+	}
+
+	explain := "EXPLAIN (FORMAT JSON) "
+	if config.analyze {
+		explain = "EXPLAIN (ANALYZE, FORMAT JSON) "
+	}
+	var data []byte
+	if err := tx.QueryRow(ctx, explain+query, args...).Scan(&data); err != nil {
+		return nil, fmt.Errorf("failed to explain query: %w", err)
+	}
+
+	var plans []*QueryPlan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, fmt.Errorf("failed to parse query plan: %w", err)
+	}
+	if len(plans) != 1 || plans[0].Plan == nil {
+		return nil, fmt.Errorf("failed to parse query plan: expected one plan, got %d", len(plans))
+	}
+	return plans[0], nil
+}
+
+// PlanCheck checks a query plan, returning an error describing
+// the violation if it fails.
+type PlanCheck func(plan *QueryPlan) error
+
+// UsesIndex checks that the plan scans the index with the given name.
+func UsesIndex(name string) PlanCheck {
+	return func(plan *QueryPlan) error {
+		for _, node := range plan.Nodes() {
+			if node.IndexName == name {
+				return nil
+			}
+		}
+		return fmt.Errorf("plan does not use index %s", name)
+	}
+}
+
+// NoSeqScanOn checks that the plan has no sequential scan on table.
+func NoSeqScanOn(table string) PlanCheck {
+	return func(plan *QueryPlan) error {
+		for _, node := range plan.Nodes() {
+			if node.NodeType != "Seq Scan" {
+				continue
+			}
+			if node.RelationName == table || node.Schema+"."+node.RelationName == table {
+				return fmt.Errorf("plan has a sequential scan on %s", table)
+			}
+		}
+		return nil
+	}
+}
+
+// MaxTotalCost checks that the estimated total cost of the plan
+// does not exceed cost.
+func MaxTotalCost(cost float64) PlanCheck {
+	return func(plan *QueryPlan) error {
+		if plan.Plan.TotalCost > cost {
+			return fmt.Errorf("plan total cost %.2f exceeds %.2f", plan.Plan.TotalCost, cost)
+		}
+		return nil
+	}
+}
+
+// EstimatedRowsBetween checks that the planner estimates the query
+// to return between minRows and maxRows rows, inclusive.
+func EstimatedRowsBetween(minRows, maxRows float64) PlanCheck {
+	return func(plan *QueryPlan) error {
+		if rows := plan.Plan.PlanRows; rows < minRows || rows > maxRows {
+			return fmt.Errorf("plan estimates %.0f rows, expected between %.0f and %.0f", rows, minRows, maxRows)
+		}
+		return nil
+	}
+}
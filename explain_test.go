@@ -0,0 +1,96 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+	"github.com/andrei-polukhin/pgdbtemplate-pgx/pgdbtemplatepgxtest"
+)
+
+// explainSchema has an indexed and a non-indexed column.
+const explainSchema = `
+	CREATE TABLE users (id SERIAL PRIMARY KEY, email TEXT NOT NULL, name TEXT);
+	CREATE INDEX users_email_idx ON users (email);
+	INSERT INTO users (email, name) SELECT 'user' || i || '@example.com', 'name' || i FROM generate_series(1, 100) i;
+	ANALYZE users;
+`
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	_, err := conn.ExecContext(ctx, explainSchema)
+	c.Assert(err, qt.IsNil)
+
+	c.Run("Index scan", func(c *qt.C) {
+		plan, err := pgdbtemplatepgx.Explain(ctx, conn,
+			"SELECT * FROM users WHERE email = $1", []any{"user1@example.com"},
+			pgdbtemplatepgx.ExplainSetting("enable_seqscan", "off"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(plan.Nodes(), qt.Not(qt.HasLen), 0)
+
+		failures := recordFailures(t, func(tb testing.TB) {
+			pgdbtemplatepgxtest.AssertPlan(tb, plan,
+				pgdbtemplatepgx.UsesIndex("users_email_idx"),
+				pgdbtemplatepgx.NoSeqScanOn("users"),
+				pgdbtemplatepgx.EstimatedRowsBetween(1, 1),
+				pgdbtemplatepgx.MaxTotalCost(1000),
+			)
+		})
+		c.Assert(failures, qt.HasLen, 0)
+	})
+
+	c.Run("Failed checks print the plan", func(c *qt.C) {
+		plan, err := pgdbtemplatepgx.Explain(ctx, conn, "SELECT * FROM users WHERE name = 'name1'", nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(plan.Plan.NodeType, qt.Equals, "Seq Scan")
+		c.Assert(plan.Plan.RelationName, qt.Equals, "users")
+		c.Assert(plan.Plan.Filter, qt.Equals, "(name = 'name1'::text)")
+
+		failures := recordFailures(t, func(tb testing.TB) {
+			pgdbtemplatepgxtest.AssertPlan(tb, plan,
+				pgdbtemplatepgx.UsesIndex("users_email_idx"),
+				pgdbtemplatepgx.NoSeqScanOn("public.users"),
+				pgdbtemplatepgx.EstimatedRowsBetween(2, 10),
+				pgdbtemplatepgx.MaxTotalCost(0.5),
+			)
+		})
+		c.Assert(failures, qt.HasLen, 1)
+		c.Assert(failures[0], qt.Matches, `query plan checks failed:
+	plan does not use index users_email_idx
+	plan has a sequential scan on public.users
+	plan estimates 1 rows, expected between 2 and 10
+	plan total cost \d+\.\d\d exceeds 0\.50
+plan:
+Seq Scan on users  \(cost=0\.00\.\.\d+\.\d\d rows=1 width=\d+\)
+      Filter: \(name = 'name1'::text\)
+`)
+	})
+
+	c.Run("Analyze rolls back", func(c *qt.C) {
+		plan, err := pgdbtemplatepgx.Explain(ctx, conn, "DELETE FROM users", nil, pgdbtemplatepgx.ExplainAnalyze())
+		c.Assert(err, qt.IsNil)
+		c.Assert(plan.Plan.NodeType, qt.Equals, "Delete")
+		c.Assert(plan.ExecutionTime > 0, qt.IsTrue)
+		c.Assert(plan.Plan.Plans[0].ActualRows, qt.Equals, float64(100))
+
+		var count int
+		c.Assert(conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count), qt.IsNil)
+		c.Assert(count, qt.Equals, 100)
+	})
+
+	c.Run("Invalid query", func(c *qt.C) {
+		_, err := pgdbtemplatepgx.Explain(ctx, conn, "SELECT * FROM missing", nil)
+		c.Assert(err, qt.ErrorMatches, `failed to explain query: .*relation "missing" does not exist.*`)
+	})
+}
@@ -0,0 +1,438 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Factory inserts test rows, generating values for the columns a test
+// does not care about.
+//
+// Tables are introspected through the system catalogs on first use. Only
+// required columns, i.e. NOT NULL columns without a default, are
+// generated; all others are left to the database. Generated values are
+// unique per factory where the type allows it, and single-column CHECK
+// constraints and domain constraints are satisfied where feasible by
+// trying candidate values against them. Enums get one of their labels,
+// and required foreign keys get a parent row created by the factory.
+//
+// A Factory is safe for concurrent use.
+type Factory struct {
+	conn    *DatabaseConnection
+	counter int64
+
+	mu     sync.Mutex
+	tables map[string]*factoryTable
+}
+
+// factoryTable describes a table for the factory.
+type factoryTable struct {
+	name        string
+	columns     []*factoryColumn
+	columnIndex map[string]*factoryColumn
+	foreignKeys []factoryForeignKey
+}
+
+// factoryColumn describes a column for the factory.
+type factoryColumn struct {
+	name     string
+	typeName string // As formatted by format_type, including modifiers.
+	typmod   int32
+	required bool
+
+	// Base type, which is the column type unless it is a domain.
+	baseOID      uint32
+	baseCategory string // typcategory.
+	domain       bool
+
+	enumLabels []string
+	checks     []string // Single-column CHECK expressions.
+	literals   []string // Literals of CHECK and domain constraints.
+}
+
+// factoryForeignKey describes a foreign key of a table.
+type factoryForeignKey struct {
+	name       string
+	refTable   string
+	columns    []string
+	refColumns []string
+}
+
+// NewFactory returns a Factory inserting rows into the database behind
+// conn, which must be a *DatabaseConnection.
+func NewFactory(conn pgdbtemplate.DatabaseConnection) (*Factory, error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok {
+		return nil, fmt.Errorf("Factory requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+	return &Factory{
+		conn:   pgxConn,
+		tables: make(map[string]*factoryTable),
+	}, nil
+}
+
+// Insert inserts a row into table and returns the inserted row, including
+// values set by the database, keyed by column name.
+//
+// Values in overrides are used as given and are encoded by pgx for the
+// type of their column. Overriding any column of a foreign key, even with
+// nil, stops the factory from creating a parent row for it.
+func (f *Factory) Insert(ctx context.Context, table string, overrides map[string]any) (map[string]any, error) {
+	row, err := f.insert(ctx, table, overrides, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert into %s: %w", table, err)
+	}
+	return row, nil
+}
+
+// insert inserts a row into table. Tables whose rows are being created
+// down the stack are in creating, to detect foreign key cycles.
+func (f *Factory) insert(ctx context.Context, table string, overrides map[string]any, creating []string) (map[string]any, error) {
+	t, err := f.table(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range creating {
+		if parent == t.name {
+			return nil, fmt.Errorf("foreign key cycle through table %s: %s", t.name, strings.Join(append(creating, t.name), " -> "))
+		}
+	}
+
+	values := make(map[string]any, len(overrides))
+	for name, value := range overrides {
+		if _, ok := t.columnIndex[name]; !ok {
+			return nil, fmt.Errorf("table %s has no column %q", t.name, name)
+		}
+		values[name] = value
+	}
+
+	for _, fk := range t.foreignKeys {
+		if !t.needsParent(fk, values) {
+			continue
+		}
+		parent, err := f.insert(ctx, fk.refTable, nil, append(creating, t.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parent row for foreign key %s: %w", fk.name, err)
+		}
+		for i, column := range fk.columns {
+			values[column] = parent[fk.refColumns[i]]
+		}
+	}
+
+	var (
+		columns      []string
+		placeholders []string
+		args         []any
+	)
+	n := atomic.AddInt64(&f.counter, 1)
+	for _, column := range t.columns {
+		value, set := values[column.name]
+		switch {
+		case set:
+			args = append(args, value)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		case column.required:
+			text, err := f.generate(ctx, column, n)
+			if err != nil {
+				return nil, err
+			}
+			// Generated values are passed as text for the database
+			// to parse, which works for every type, including enums.
+			args = append(args, text)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::text::%s", len(args), column.typeName))
+		default:
+			continue
+		}
+		columns = append(columns, pgx.Identifier{column.name}.Sanitize())
+	}
+
+	query := "INSERT INTO " + t.name + " DEFAULT VALUES RETURNING *"
+	if len(columns) > 0 {
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING *",
+			t.name, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	}
+	rows, err := f.conn.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToMap)
+}
+
+// needsParent reports whether a parent row must be created for fk, which
+// is when none of its columns is set and any of them is required.
+func (t *factoryTable) needsParent(fk factoryForeignKey, values map[string]any) bool {
+	required := false
+	for _, column := range fk.columns {
+		if _, set := values[column]; set {
+			return false
+		}
+		required = required || t.columnIndex[column].required
+	}
+	return required
+}
+
+// table returns the description of table, introspecting it on first use.
+func (f *Factory) table(ctx context.Context, table string) (*factoryTable, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t, ok := f.tables[table]; ok {
+		return t, nil
+	}
+	t, err := f.introspect(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	f.tables[table] = t
+	return t, nil
+}
+
+// introspect describes table using the system catalogs.
+func (f *Factory) introspect(ctx context.Context, table string) (*factoryTable, error) {
+	var (
+		oid  *uint32
+		name string
+	)
+	err := f.conn.Pool.QueryRow(ctx,
+		"SELECT to_regclass($1)::oid, COALESCE(to_regclass($1)::text, '')", table,
+	).Scan(&oid, &name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve table %s: %w", table, err)
+	}
+	if oid == nil {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	t := &factoryTable{name: name, columnIndex: make(map[string]*factoryColumn)}
+
+	columnsQuery := `
+		SELECT
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			a.atttypmod,
+			(a.attnotnull OR t.typnotnull)
+				AND NOT (a.atthasdef OR a.attidentity <> '' OR a.attgenerated <> '' OR t.typdefault IS NOT NULL),
+			b.oid,
+			b.typcategory::text,
+			t.typtype = 'd',
+			COALESCE((
+				SELECT array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+				FROM pg_enum e WHERE e.enumtypid = b.oid
+			), '{}'),
+			COALESCE((
+				SELECT array_agg(pg_get_constraintdef(c.oid) ORDER BY c.conname)
+				FROM pg_constraint c
+				WHERE c.contype = 'c' AND c.conrelid = a.attrelid AND c.conkey = ARRAY[a.attnum]
+			), '{}'),
+			COALESCE((
+				SELECT array_agg(pg_get_constraintdef(c.oid) ORDER BY c.conname)
+				FROM pg_constraint c
+				WHERE c.contype = 'c' AND c.contypid = a.atttypid
+			), '{}')
+		FROM pg_attribute a
+		JOIN pg_type t ON t.oid = a.atttypid
+		JOIN pg_type b ON b.oid = CASE WHEN t.typtype = 'd' THEN t.typbasetype ELSE t.oid END
+		WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum
+	`
+	rows, err := f.conn.Pool.Query(ctx, columnsQuery, *oid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns of %s: %w", name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		column := &factoryColumn{}
+		var tableChecks, domainChecks []string
+		err := rows.Scan(&column.name, &column.typeName, &column.typmod, &column.required,
+			&column.baseOID, &column.baseCategory, &column.domain,
+			&column.enumLabels, &tableChecks, &domainChecks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan column of %s: %w", name, err)
+		}
+		for _, check := range tableChecks {
+			column.checks = append(column.checks, checkExpression(check))
+		}
+		for _, check := range append(tableChecks, domainChecks...) {
+			column.literals = append(column.literals, constraintLiterals(check)...)
+		}
+		t.columns = append(t.columns, column)
+		t.columnIndex[column.name] = column
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query columns of %s: %w", name, err)
+	}
+
+	fkQuery := `
+		SELECT c.conname, c.confrelid::regclass::text,
+			array_agg(a.attname::text ORDER BY k.ord),
+			array_agg(fa.attname::text ORDER BY k.ord)
+		FROM pg_constraint c
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, fattnum, ord)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = k.fattnum
+		WHERE c.conrelid = $1 AND c.contype = 'f'
+		GROUP BY c.oid, c.conname, c.confrelid
+		ORDER BY c.conname
+	`
+	rows, err = f.conn.Pool.Query(ctx, fkQuery, *oid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query foreign keys of %s: %w", name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fk factoryForeignKey
+		if err := rows.Scan(&fk.name, &fk.refTable, &fk.columns, &fk.refColumns); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key of %s: %w", name, err)
+		}
+		t.foreignKeys = append(t.foreignKeys, fk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query foreign keys of %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// generate returns the text of a value for column, given the unique
+// number n of the row being inserted.
+func (f *Factory) generate(ctx context.Context, column *factoryColumn, n int64) (string, error) {
+	candidates := column.candidates(n)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("cannot generate a value for column %s of type %s, set it explicitly", column.name, column.typeName)
+	}
+	if len(column.checks) == 0 && !column.domain {
+		return candidates[0], nil
+	}
+
+	// Evaluate the constraints against every candidate. Casting to the
+	// column type enforces domain constraints, raising an error for
+	// candidates violating them.
+	condition := "true"
+	if len(column.checks) > 0 {
+		condition = "(" + strings.Join(column.checks, ") AND (") + ")"
+	}
+	query := fmt.Sprintf("SELECT %s IS NOT FALSE FROM (SELECT $1::text::%s AS %s) AS candidate",
+		condition, column.typeName, pgx.Identifier{column.name}.Sanitize())
+	for _, candidate := range candidates {
+		var ok bool
+		if err := f.conn.Pool.QueryRow(ctx, query, candidate).Scan(&ok); err == nil && ok {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("cannot generate a value for column %s satisfying its constraints, set it explicitly", column.name)
+}
+
+// candidates returns the texts of candidate values for column, best first.
+func (c *factoryColumn) candidates(n int64) []string {
+	number := strconv.FormatInt(n, 10)
+	var candidates []string
+	switch c.baseCategory {
+	case "N": // Numeric.
+		candidates = append(candidates, number)
+		for _, literal := range c.literals {
+			if v, err := strconv.ParseFloat(literal, 64); err == nil {
+				candidates = append(candidates, literal,
+					strconv.FormatFloat(v+1, 'f', -1, 64), strconv.FormatFloat(v-1, 'f', -1, 64))
+			}
+		}
+		candidates = append(candidates, "0", "1")
+	case "S": // String.
+		base := c.name + "-" + number
+		if maxLength := c.typmod - 4; maxLength > 0 { // Modifiers of char(n) and varchar(n).
+			base = resizeString(base, int(maxLength))
+		}
+		candidates = append(candidates, base)
+		for _, literal := range c.literals {
+			if length, err := strconv.Atoi(literal); err == nil && length > 0 {
+				candidates = append(candidates, resizeString(base, length), resizeString(base, length+1))
+			} else {
+				candidates = append(candidates, literal)
+			}
+		}
+	case "B": // Boolean.
+		candidates = append(candidates, "false", "true")
+	case "D": // Date and time.
+		switch c.baseOID {
+		case pgtype.DateOID:
+			candidates = append(candidates, "2000-01-01")
+		case pgtype.TimeOID:
+			candidates = append(candidates, "00:00:00")
+		default:
+			candidates = append(candidates, "2000-01-01 00:00:00+00")
+		}
+		candidates = append(candidates, c.literals...)
+		candidates = append(candidates, "now")
+	case "T": // Timespan.
+		candidates = append(candidates, "1 second")
+	case "I": // Network address.
+		candidates = append(candidates, "127.0.0.1")
+	case "E": // Enum.
+		candidates = append(candidates, c.enumLabels...)
+	case "A": // Array.
+		candidates = append(candidates, "{}")
+	case "R": // Range.
+		candidates = append(candidates, "empty")
+	default:
+		switch c.baseOID {
+		case pgtype.UUIDOID:
+			candidates = append(candidates, fmt.Sprintf("00000000-0000-4000-8000-%012x", n))
+		case pgtype.JSONOID, pgtype.JSONBOID:
+			candidates = append(candidates, "{}")
+		case pgtype.ByteaOID:
+			candidates = append(candidates, `\x`)
+		case pgtype.MacaddrOID:
+			candidates = append(candidates, "08:00:2b:01:02:03")
+		case pgtype.PointOID:
+			candidates = append(candidates, "(0,0)")
+		}
+	}
+	return candidates
+}
+
+var (
+	// checkRegexp extracts the expression of a CHECK constraint definition.
+	checkRegexp = regexp.MustCompile(`^CHECK \((.*)\)( NOT VALID)?$`)
+
+	// literalRegexp matches the string and number literals of an expression.
+	literalRegexp = regexp.MustCompile(`'((?:[^']|'')*)'|-?\b\d+(?:\.\d+)?\b`)
+)
+
+// checkExpression returns the expression of a CHECK constraint definition.
+func checkExpression(definition string) string {
+	if m := checkRegexp.FindStringSubmatch(definition); m != nil {
+		return m[1]
+	}
+	return definition
+}
+
+// constraintLiterals returns the string and number literals of a
+// constraint definition, which make likely candidates to satisfy it.
+func constraintLiterals(definition string) []string {
+	var literals []string
+	for _, m := range literalRegexp.FindAllStringSubmatch(definition, -1) {
+		if strings.HasPrefix(m[0], "'") {
+			literals = append(literals, strings.ReplaceAll(m[1], "''", "'"))
+		} else {
+			literals = append(literals, m[0])
+		}
+	}
+	return literals
+}
+
+// resizeString pads s with leading "x" characters or cuts its leading
+// characters to make it length characters long, keeping the unique
+// suffix of generated values.
+func resizeString(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) >= length {
+		return string(runes[len(runes)-length:])
+	}
+	return strings.Repeat("x", length-len(runes)) + s
+}
@@ -0,0 +1,146 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// factorySchema has required columns of many types, constraints
+// and a chain of required foreign keys.
+const factorySchema = `
+	CREATE TYPE status AS ENUM ('active', 'banned');
+	CREATE DOMAIN positive_int AS INT CHECK (VALUE > 100);
+	CREATE TABLE organizations (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		code VARCHAR(3) NOT NULL CHECK (char_length(code) = 3)
+	);
+	CREATE TABLE users (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		organization_id INT NOT NULL REFERENCES organizations (id),
+		email TEXT NOT NULL UNIQUE,
+		status status NOT NULL,
+		plan TEXT NOT NULL CHECK (plan IN ('free', 'pro')),
+		age INT NOT NULL CHECK (age >= 18),
+		score positive_int NOT NULL,
+		external_id UUID NOT NULL UNIQUE,
+		active BOOLEAN NOT NULL,
+		born DATE NOT NULL,
+		settings JSONB NOT NULL,
+		tags TEXT[] NOT NULL,
+		nickname TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE posts (
+		id SERIAL PRIMARY KEY,
+		author_id BIGINT NOT NULL REFERENCES users (id),
+		editor_id BIGINT REFERENCES users (id),
+		title TEXT NOT NULL
+	);
+	CREATE TABLE nodes (
+		id SERIAL PRIMARY KEY,
+		parent_id INT NOT NULL REFERENCES nodes (id)
+	);
+	CREATE TABLE points (geom POLYGON NOT NULL);
+`
+
+func TestFactory(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	_, err := conn.ExecContext(ctx, factorySchema)
+	c.Assert(err, qt.IsNil)
+
+	factory, err := pgdbtemplatepgx.NewFactory(conn)
+	c.Assert(err, qt.IsNil)
+
+	c.Run("Generate required columns and parent rows", func(c *qt.C) {
+		post, err := factory.Insert(ctx, "posts", nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(post["author_id"], qt.Not(qt.IsNil))
+		c.Assert(post["editor_id"], qt.IsNil)
+
+		var (
+			status, plan, code string
+			age, score         int
+			nickname           *string
+		)
+		err = conn.QueryRowContext(ctx, `
+			SELECT u.status::text, u.plan, u.age, u.score, u.nickname, o.code
+			FROM users u JOIN organizations o ON o.id = u.organization_id
+			WHERE u.id = $1`, post["author_id"],
+		).Scan(&status, &plan, &age, &score, &nickname, &code)
+		c.Assert(err, qt.IsNil)
+		c.Assert(status, qt.Equals, "active")
+		c.Assert(plan, qt.Equals, "free")
+		c.Assert(age >= 18, qt.IsTrue)
+		c.Assert(score > 100, qt.IsTrue)
+		c.Assert(nickname, qt.IsNil)
+		c.Assert(code, qt.HasLen, 3)
+	})
+
+	c.Run("Generated values are unique", func(c *qt.C) {
+		for i := 0; i < 3; i++ {
+			_, err := factory.Insert(ctx, "users", nil)
+			c.Assert(err, qt.IsNil)
+		}
+	})
+
+	c.Run("Overrides", func(c *qt.C) {
+		org, err := factory.Insert(ctx, "organizations", map[string]any{"name": "acme"})
+		c.Assert(err, qt.IsNil)
+		c.Assert(org["name"], qt.Equals, "acme")
+
+		user, err := factory.Insert(ctx, "users", map[string]any{
+			"organization_id": org["id"],
+			"status":          "banned",
+			"nickname":        "nick",
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(user["organization_id"], qt.Equals, org["id"])
+		c.Assert(user["status"], qt.Equals, "banned")
+		c.Assert(user["nickname"], qt.Equals, "nick")
+		c.Assert(user["created_at"], qt.Not(qt.IsNil))
+
+		var organizations int
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM organizations WHERE name = 'acme'").Scan(&organizations)
+		c.Assert(err, qt.IsNil)
+		c.Assert(organizations, qt.Equals, 1)
+	})
+
+	c.Run("Errors", func(c *qt.C) {
+		tests := []struct {
+			table     string
+			overrides map[string]any
+			pattern   string
+		}{{
+			table:   "missing",
+			pattern: `failed to insert into missing: table missing does not exist`,
+		}, {
+			table:     "posts",
+			overrides: map[string]any{"nope": 1},
+			pattern:   `failed to insert into posts: table posts has no column "nope"`,
+		}, {
+			table:   "nodes",
+			pattern: `failed to insert into nodes: failed to create parent row for foreign key nodes_parent_id_fkey: foreign key cycle through table nodes: nodes -> nodes`,
+		}, {
+			table:   "points",
+			pattern: `failed to insert into points: cannot generate a value for column geom of type polygon, set it explicitly`,
+		}}
+		for _, test := range tests {
+			_, err := factory.Insert(ctx, test.table, test.overrides)
+			c.Assert(err, qt.ErrorMatches, test.pattern)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// fingerprintVersion is mixed into every fingerprint so that changes to
+// the fingerprint inputs invalidate existing templates.
+const fingerprintVersion = "pgdbtemplate-fingerprint-v1"
+
+// fingerprintCommentPrefix prefixes the fingerprint stored as the comment
+// of a template database.
+const fingerprintCommentPrefix = "pgdbtemplate:fingerprint="
+
+// MigrationFingerprint hashes everything that determines the contents of
+// a template database built from the migration files in paths.
+//
+// Files are collected and ordered like pgdbtemplate.FileMigrationRunner
+// does: the .sql files of every path, ordered by orderingFunc within each
+// path. The fingerprint covers the file names, contents and order, the
+// server version and the extensions available on the server behind conn.
+// Upon the nil function provided, an alphabetical sorting will be used.
+func MigrationFingerprint(ctx context.Context, conn pgdbtemplate.DatabaseConnection, paths []string, orderingFunc func([]string) []string) (string, error) {
+	files, err := readMigrationFiles(paths, orderingFunc)
+	if err != nil {
+		return "", err
+	}
+	return fingerprintMigrationFiles(ctx, conn, files)
+}
+
+// fingerprintMigrationFiles hashes files together with the server version
+// and available extensions.
+func fingerprintMigrationFiles(ctx context.Context, conn pgdbtemplate.DatabaseConnection, files []migrationFile) (string, error) {
+	var serverVersion string
+	if err := conn.QueryRowContext(ctx, "SELECT current_setting('server_version_num')").Scan(&serverVersion); err != nil {
+		return "", fmt.Errorf("failed to query server version: %w", err)
+	}
+
+	var extensions string
+	extensionsQuery := `
+		SELECT COALESCE(string_agg(name || '=' || COALESCE(default_version, ''), ',' ORDER BY name), '')
+		FROM pg_available_extensions
+	`
+	if err := conn.QueryRowContext(ctx, extensionsQuery).Scan(&extensions); err != nil {
+		return "", fmt.Errorf("failed to query available extensions: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", fingerprintVersion, serverVersion, extensions)
+	for _, file := range files {
+		// Length-prefix contents so that file boundaries are unambiguous.
+		fmt.Fprintf(h, "%s\x00%d\x00", file.name, len(file.content))
+		h.Write(file.content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
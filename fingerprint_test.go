@@ -0,0 +1,135 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// writeMigrations writes migration files into a new temporary directory.
+func writeMigrations(c *qt.C, files map[string]string) string {
+	dir := c.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, qt.IsNil)
+	}
+	return dir
+}
+
+func TestMigrationFingerprint(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer provider.Close()
+
+	conn, err := provider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	migrations := map[string]string{
+		"001_create.sql": "CREATE TABLE t (id INT);",
+		"002_insert.sql": "INSERT INTO t VALUES (1);",
+	}
+	dir1 := writeMigrations(c, migrations)
+	dir2 := writeMigrations(c, migrations)
+
+	fp1, err := pgdbtemplatepgx.MigrationFingerprint(ctx, conn, []string{dir1}, nil)
+	c.Assert(err, qt.IsNil)
+	fp2, err := pgdbtemplatepgx.MigrationFingerprint(ctx, conn, []string{dir2}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fp1, qt.Equals, fp2)
+
+	// Changed contents change the fingerprint.
+	migrations["002_insert.sql"] = "INSERT INTO t VALUES (2);"
+	dir3 := writeMigrations(c, migrations)
+	fp3, err := pgdbtemplatepgx.MigrationFingerprint(ctx, conn, []string{dir3}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fp3, qt.Not(qt.Equals), fp1)
+
+	// So does the order.
+	reversed := func(files []string) []string {
+		sorted := pgdbtemplate.AlphabeticalMigrationFilesSorting(files)
+		for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+			sorted[i], sorted[j] = sorted[j], sorted[i]
+		}
+		return sorted
+	}
+	fp4, err := pgdbtemplatepgx.MigrationFingerprint(ctx, conn, []string{dir1}, reversed)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fp4, qt.Not(qt.Equals), fp1)
+
+	_, err = pgdbtemplatepgx.MigrationFingerprint(ctx, conn, []string{"/nonexistent"}, nil)
+	c.Assert(err, qt.ErrorMatches, "failed to read directory.*")
+}
+
+func TestTemplateCoordinatorWithFingerprint(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	templateName := fmt.Sprintf("pgx_fingerprinted_%d_%d", time.Now().UnixNano(), os.Getpid())
+	migrations := map[string]string{
+		"001_create.sql": "CREATE TABLE test_table (id SERIAL PRIMARY KEY, name TEXT);",
+		"002_insert.sql": "INSERT INTO test_table (name) VALUES ('a'), ('b');",
+	}
+
+	// initialize runs one coordinated initialization, standing in
+	// for a single test run, and returns the number of rows in
+	// test_table of a fresh test database.
+	initialize := func(dir string) (runs int32, rows int) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		runner := &countingMigrationRunner{runner: pgdbtemplate.NewFileMigrationRunner([]string{dir}, nil)}
+		coordinator, err := pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    runner,
+			TemplateName:       templateName,
+		}, pgdbtemplatepgx.WithMigrationFingerprint([]string{dir}, nil))
+		c.Assert(err, qt.IsNil)
+		c.Assert(coordinator.Initialize(ctx), qt.IsNil)
+
+		conn, dbName, err := coordinator.TemplateManager().CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&rows)
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+
+		// Cleanup drops the test database but keeps the template.
+		c.Assert(coordinator.Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(c, dbName), qt.IsFalse)
+		c.Assert(databaseExists(c, templateName), qt.IsTrue)
+		return runner.runs.Load(), rows
+	}
+	// Drop the template kept for later runs once the test is done:
+	// cleanups run last in, first out, so the template manager adopts
+	// the template before it is cleaned up.
+	templates := newTestTemplateManager(c, &pgdbtemplate.NoOpMigrationRunner{}, withTemplateName(templateName))
+	c.Cleanup(func() { c.Check(templates.Initialize(ctx), qt.IsNil) })
+
+	// First run builds the template.
+	runs, rows := initialize(writeMigrations(c, migrations))
+	c.Assert(runs, qt.Equals, int32(1))
+	c.Assert(rows, qt.Equals, 2)
+
+	// Unchanged migrations reuse it.
+	runs, rows = initialize(writeMigrations(c, migrations))
+	c.Assert(runs, qt.Equals, int32(0))
+	c.Assert(rows, qt.Equals, 2)
+
+	// Changed migrations rebuild it.
+	migrations["003_insert.sql"] = "INSERT INTO test_table (name) VALUES ('c');"
+	runs, rows = initialize(writeMigrations(c, migrations))
+	c.Assert(runs, qt.Equals, int32(1))
+	c.Assert(rows, qt.Equals, 3)
+}
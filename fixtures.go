@@ -0,0 +1,664 @@
+package pgdbtemplatepgx
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"gopkg.in/yaml.v3"
+)
+
+// FixtureLoader bulk-loads table fixtures with COPY.
+//
+// Every fixture file holds the rows of one table, named after the file
+// without its extension, e.g. "users.csv" or "billing.invoices.yaml".
+// Supported formats are:
+//
+//   - CSV (.csv): a header row with column names, then one row per record.
+//     Unquoted empty fields are loaded as NULL, quoted ones ("") as empty
+//     strings; see WithCSVNull.
+//   - JSON (.json): an array of objects keyed by column name.
+//   - YAML (.yaml, .yml): a sequence of mappings keyed by column name.
+//
+// Columns omitted from a JSON or YAML record get their default values.
+// Values are converted to the column types through their PostgreSQL text
+// representation; lists are loaded into array columns, and nested objects
+// into json and jsonb columns.
+//
+// Tables are loaded in foreign key order, parents first, within a single
+// transaction. Afterwards, sequences owned by the loaded columns are moved
+// past the loaded values, so that later inserts do not collide.
+type FixtureLoader struct {
+	source  migrationSource
+	paths   []string
+	csvNull string // Unquoted CSV field loaded as NULL.
+}
+
+// NewFixtureLoader creates a loader for the fixture files in paths.
+//
+// Each path is either a fixture file or a directory of fixture files.
+func NewFixtureLoader(paths ...string) *FixtureLoader {
+	return &FixtureLoader{source: osMigrationSource, paths: paths}
+}
+
+// NewFSFixtureLoader creates a loader for the fixture files in paths of fsys.
+func NewFSFixtureLoader(fsys fs.FS, paths ...string) *FixtureLoader {
+	return &FixtureLoader{source: fsMigrationSource(fsys), paths: paths}
+}
+
+// WithCSVNull returns a copy of l that loads unquoted CSV fields equal to
+// marker as NULL instead of unquoted empty fields, e.g. `\N` as written by
+// COPY ... WITH (FORMAT csv, NULL '\N'). Quoted fields are never NULL, and
+// unquoted empty fields are empty strings unless marker is empty.
+func (l *FixtureLoader) WithCSVNull(marker string) *FixtureLoader {
+	loader := *l
+	loader.csvNull = marker
+	return &loader
+}
+
+// SeedAfterMigrations returns a migration runner that runs runner
+// and then loads the fixtures of loader.
+//
+// Use it as the MigrationRunner of pgdbtemplate.Config to seed the
+// template database, so that every test database starts with the
+// shared seed data.
+func SeedAfterMigrations(runner pgdbtemplate.MigrationRunner, loader *FixtureLoader) pgdbtemplate.MigrationRunner {
+	return &seedingMigrationRunner{runner: runner, loader: loader}
+}
+
+// seedingMigrationRunner runs migrations and loads fixtures.
+type seedingMigrationRunner struct {
+	runner pgdbtemplate.MigrationRunner
+	loader *FixtureLoader
+}
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+func (r *seedingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	if err := r.runner.RunMigrations(ctx, conn); err != nil {
+		return err
+	}
+	return r.loader.Load(ctx, conn)
+}
+
+// fixture holds the records of one table.
+type fixture struct {
+	path    string
+	table   string
+	records []fixtureRecord
+}
+
+// fixtureRecord is a record of a fixture: values by column name.
+type fixtureRecord map[string]any
+
+// fixtureColumn describes a column of a fixture table.
+type fixtureColumn struct {
+	oid      uint32
+	typeName string
+	elemOID  uint32 // Element type of array columns.
+	category string // pg_type.typcategory.
+}
+
+// Load loads the fixtures into the database behind conn,
+// which must be a *DatabaseConnection.
+func (l *FixtureLoader) Load(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok {
+		return fmt.Errorf("FixtureLoader requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+
+	fixtures, err := l.readFixtures()
+	if err != nil {
+		return err
+	}
+	if len(fixtures) == 0 {
+		return nil
+	}
+
+	poolConn, err := pgxConn.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer poolConn.Release()
+
+	tx, err := poolConn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Rolling back after Commit is a no-op.
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	oids, err := resolveFixtureTables(ctx, tx, fixtures)
+	if err != nil {
+		return err
+	}
+	ordered, err := orderFixtures(ctx, tx, fixtures, oids)
+	if err != nil {
+		return err
+	}
+	for _, f := range ordered {
+		if err := copyFixture(ctx, tx, f, oids[f.table]); err != nil {
+			return fmt.Errorf("failed to load fixture %q: %w", f.path, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit fixtures: %w", err)
+	}
+	return nil
+}
+
+// readFixtures reads the fixture files of all paths.
+func (l *FixtureLoader) readFixtures() ([]*fixture, error) {
+	var fixtures []*fixture
+	tables := make(map[string]string)
+	addFixture := func(path string) error {
+		content, err := l.source.readFile(path) // #nosec G304 -- Fixture files are controlled by the test.
+		if err != nil {
+			return fmt.Errorf("failed to read fixture file %q: %w", path, err)
+		}
+		f, err := parseFixture(path, l.source.base(path), content, l.csvNull)
+		if err != nil {
+			return err
+		}
+		if other, exists := tables[f.table]; exists {
+			return fmt.Errorf("fixture files %q and %q load the same table %s", other, path, f.table)
+		}
+		tables[f.table] = path
+		fixtures = append(fixtures, f)
+		return nil
+	}
+
+	for _, path := range l.paths {
+		entries, err := l.source.readDir(path)
+		if err != nil {
+			// Not a directory, so a single fixture file.
+			if err := addFixture(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, entry := range entries { // Sorted by name.
+			if entry.IsDir() || fixtureFormat(entry.Name()) == "" {
+				continue
+			}
+			if err := addFixture(l.source.join(path, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fixtures, nil
+}
+
+// fixtureFormat returns the extension of a supported fixture file name.
+func fixtureFormat(name string) string {
+	for _, ext := range []string{".csv", ".json", ".yaml", ".yml"} {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// parseFixture parses the fixture file at path with base name name,
+// loading unquoted CSV fields equal to csvNull as NULL.
+func parseFixture(path, name string, content []byte, csvNull string) (*fixture, error) {
+	ext := fixtureFormat(name)
+	f := &fixture{path: path, table: strings.TrimSuffix(name, ext)}
+
+	var err error
+	switch ext {
+	case ".csv":
+		f.records, err = parseCSVFixture(content, csvNull)
+	case ".json":
+		f.records, err = parseJSONFixture(content)
+	case ".yaml", ".yml":
+		f.records, err = parseYAMLFixture(content)
+	default:
+		err = errors.New("unsupported fixture format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fixture file %q: %w", path, err)
+	}
+	return f, nil
+}
+
+// parseCSVFixture parses CSV records with a header row,
+// loading unquoted fields equal to null as NULL.
+func parseCSVFixture(content []byte, null string) ([]fixtureRecord, error) {
+	// encoding/csv drops the quotes, so find them in content
+	// through the positions of the fields.
+	lineStarts := []int{0}
+	for i, b := range content {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	quoted := func(reader *csv.Reader, field int) bool {
+		line, column := reader.FieldPos(field)
+		offset := lineStarts[line-1] + column - 1
+		return offset < len(content) && content[offset] == '"'
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []fixtureRecord
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := make(fixtureRecord, len(header))
+		for i, column := range header {
+			if row[i] == null && !quoted(reader, i) {
+				record[column] = nil
+			} else {
+				record[column] = row[i]
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// parseJSONFixture parses a JSON array of objects, keeping numbers exact.
+func parseJSONFixture(content []byte) ([]fixtureRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var records []fixtureRecord
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// parseYAMLFixture parses a YAML sequence of mappings.
+func parseYAMLFixture(content []byte) ([]fixtureRecord, error) {
+	var records []fixtureRecord
+	if err := yaml.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// resolveFixtureTables returns the OIDs of the fixture tables.
+func resolveFixtureTables(ctx context.Context, tx pgx.Tx, fixtures []*fixture) (map[string]uint32, error) {
+	oids := make(map[string]uint32, len(fixtures))
+	for _, f := range fixtures {
+		var oid *uint32
+		if err := tx.QueryRow(ctx, "SELECT to_regclass($1)::oid", f.table).Scan(&oid); err != nil {
+			return nil, fmt.Errorf("failed to resolve table %s: %w", f.table, err)
+		}
+		if oid == nil {
+			return nil, fmt.Errorf("table %s of fixture %q does not exist", f.table, f.path)
+		}
+		oids[f.table] = *oid
+	}
+	return oids, nil
+}
+
+// orderFixtures orders fixtures so that tables referenced by foreign keys
+// are loaded before the tables referencing them. Otherwise, the order of
+// fixture files is kept.
+func orderFixtures(ctx context.Context, tx pgx.Tx, fixtures []*fixture, oids map[string]uint32) ([]*fixture, error) {
+	byOID := make(map[uint32]int, len(fixtures))
+	tableOIDs := make([]uint32, 0, len(fixtures))
+	for i, f := range fixtures {
+		byOID[oids[f.table]] = i
+		tableOIDs = append(tableOIDs, oids[f.table])
+	}
+
+	query := `
+		SELECT DISTINCT conrelid::oid, confrelid::oid
+		FROM pg_constraint
+		WHERE contype = 'f' AND conrelid = ANY($1) AND confrelid = ANY($1) AND conrelid <> confrelid
+	`
+	rows, err := tx.Query(ctx, query, tableOIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query foreign keys: %w", err)
+	}
+	parents := make([][]int, len(fixtures)) // Fixture index to its parents.
+	var child, parent uint32
+	_, err = pgx.ForEachRow(rows, []any{&child, &parent}, func() error {
+		parents[byOID[child]] = append(parents[byOID[child]], byOID[parent])
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query foreign keys: %w", err)
+	}
+
+	// Depth-first topological sort, visiting fixtures in file order.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(fixtures))
+	ordered := make([]*fixture, 0, len(fixtures))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("foreign keys between fixture tables form a cycle through %s", fixtures[i].table)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, p := range parents[i] {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		ordered = append(ordered, fixtures[i])
+		return nil
+	}
+	for i := range fixtures {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// copyFixture loads the records of f into the table with tableOID.
+//
+// Records are copied in batches of records with the same columns,
+// so that omitted columns get their defaults.
+func copyFixture(ctx context.Context, tx pgx.Tx, f *fixture, tableOID uint32) error {
+	columns, err := fixtureColumns(ctx, tx, tableOID)
+	if err != nil {
+		return err
+	}
+	if err := registerFixtureTypes(ctx, tx.Conn(), columns); err != nil {
+		return err
+	}
+
+	var schemaName, tableName string
+	if err := tx.QueryRow(ctx,
+		"SELECT n.nspname, c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.oid = $1",
+		tableOID,
+	).Scan(&schemaName, &tableName); err != nil {
+		return fmt.Errorf("failed to resolve table name: %w", err)
+	}
+	table := pgx.Identifier{schemaName, tableName}
+
+	var (
+		batches     = make(map[string][][]any)
+		batchOrder  []string
+		batchColumn = make(map[string][]string)
+	)
+	for i, record := range f.records {
+		names := make([]string, 0, len(record))
+		for name := range record {
+			if _, exists := columns[name]; !exists {
+				return fmt.Errorf("record %d: table %s has no column %q", i+1, f.table, name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		values := make([]any, len(names))
+		for j, name := range names {
+			value, err := fixtureValue(tx.Conn().TypeMap(), columns[name], record[name])
+			if err != nil {
+				return fmt.Errorf("record %d, column %s: %w", i+1, name, err)
+			}
+			values[j] = value
+		}
+
+		key := strings.Join(names, ",")
+		if _, exists := batches[key]; !exists {
+			batchOrder = append(batchOrder, key)
+			batchColumn[key] = names
+		}
+		batches[key] = append(batches[key], values)
+	}
+
+	for _, key := range batchOrder {
+		if len(batchColumn[key]) == 0 {
+			// COPY needs columns, so rows of defaults are inserted.
+			for range batches[key] {
+				if _, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", table.Sanitize())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if _, err := tx.CopyFrom(ctx, table, batchColumn[key], pgx.CopyFromRows(batches[key])); err != nil {
+			return err
+		}
+	}
+	return resetFixtureSequences(ctx, tx, table)
+}
+
+// fixtureColumns returns the columns of the table with tableOID by name.
+func fixtureColumns(ctx context.Context, tx pgx.Tx, tableOID uint32) (map[string]fixtureColumn, error) {
+	query := `
+		SELECT a.attname, t.oid, t.oid::regtype::text, t.typelem, t.typcategory
+		FROM pg_attribute a JOIN pg_type t ON t.oid = a.atttypid
+		WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+	`
+	rows, err := tx.Query(ctx, query, tableOID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	columns := make(map[string]fixtureColumn)
+	var (
+		name   string
+		column fixtureColumn
+	)
+	_, err = pgx.ForEachRow(rows, []any{&name, &column.oid, &column.typeName, &column.elemOID, &column.category}, func() error {
+		columns[name] = column
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	return columns, nil
+}
+
+// registerFixtureTypes registers the column types unknown to conn,
+// e.g. enums and domains, so that values can be encoded for COPY.
+func registerFixtureTypes(ctx context.Context, conn *pgx.Conn, columns map[string]fixtureColumn) error {
+	typeMap := conn.TypeMap()
+	register := func(oid uint32) error {
+		if _, known := typeMap.TypeForOID(oid); known {
+			return nil
+		}
+		var typeName, typeType, category string
+		if err := conn.QueryRow(ctx,
+			"SELECT oid::regtype::text, typtype, typcategory FROM pg_type WHERE oid = $1", oid,
+		).Scan(&typeName, &typeType, &category); err != nil {
+			return fmt.Errorf("failed to resolve type %d: %w", oid, err)
+		}
+		if typeType == "b" && category == "S" {
+			// String types of extensions, e.g. citext, share
+			// the binary format of text.
+			typeMap.RegisterType(&pgtype.Type{Name: typeName, OID: oid, Codec: pgtype.TextCodec{}})
+			return nil
+		}
+		dataType, err := conn.LoadType(ctx, typeName)
+		if err != nil {
+			return fmt.Errorf("failed to load type %s: %w", typeName, err)
+		}
+		typeMap.RegisterType(dataType)
+		return nil
+	}
+
+	for _, column := range columns {
+		if column.elemOID != 0 && column.category == "A" {
+			if err := register(column.elemOID); err != nil {
+				return err
+			}
+		}
+		if err := register(column.oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixtureValue converts a fixture value into a value encodable
+// for column.
+func fixtureValue(typeMap *pgtype.Map, column fixtureColumn, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if column.oid == pgtype.JSONOID || column.oid == pgtype.JSONBOID {
+		if s, ok := value.(string); ok {
+			return s, nil // Already JSON text.
+		}
+		data, err := json.Marshal(jsonCompatible(value))
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+
+	switch column.oid {
+	case pgtype.TimestamptzOID, pgtype.TimestampOID, pgtype.DateOID:
+		// pgx only parses the text output format of PostgreSQL,
+		// while fixtures commonly hold ISO 8601 timestamps.
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t, nil
+				}
+			}
+		}
+	}
+
+	text, err := fixtureText(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := typeMap.Scan(column.oid, pgtype.TextFormatCode, []byte(text), &decoded); err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", column.typeName, text, err)
+	}
+	return decoded, nil
+}
+
+// fixtureText returns the PostgreSQL text representation of value.
+func fixtureText(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999999Z07:00"), nil
+	case []any:
+		// Array literal, quoting every element.
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			if elem == nil {
+				elems[i] = "NULL"
+				continue
+			}
+			text, err := fixtureText(elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+		}
+		return "{" + strings.Join(elems, ",") + "}", nil
+	}
+	return "", fmt.Errorf("unsupported value %v of type %T", value, value)
+}
+
+// jsonCompatible converts YAML mappings with non-string keys
+// into values encoding/json accepts.
+func jsonCompatible(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, elem := range v {
+			out[key] = jsonCompatible(elem)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for key, elem := range v {
+			out[fmt.Sprint(key)] = jsonCompatible(elem)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = jsonCompatible(elem)
+		}
+		return out
+	}
+	return value
+}
+
+// resetFixtureSequences moves the sequences owned by columns of table
+// past the largest loaded values.
+func resetFixtureSequences(ctx context.Context, tx pgx.Tx, table pgx.Identifier) error {
+	query := `
+		SELECT attname, sequence FROM (
+			SELECT a.attname, pg_get_serial_sequence($1::text, a.attname) AS sequence
+			FROM pg_attribute a
+			WHERE a.attrelid = $1::text::regclass AND a.attnum > 0 AND NOT a.attisdropped
+		) owned
+		WHERE sequence IS NOT NULL
+	`
+	rows, err := tx.Query(ctx, query, table.Sanitize())
+	if err != nil {
+		return fmt.Errorf("failed to query owned sequences: %w", err)
+	}
+	type ownedSequence struct{ column, sequence string }
+	var sequences []ownedSequence
+	var seq ownedSequence
+	_, err = pgx.ForEachRow(rows, []any{&seq.column, &seq.sequence}, func() error {
+		sequences = append(sequences, seq)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to query owned sequences: %w", err)
+	}
+
+	for _, seq := range sequences {
+		query := fmt.Sprintf("SELECT setval($1::text::regclass, COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			pgx.Identifier{seq.column}.Sanitize(), table.Sanitize())
+		if _, err := tx.Exec(ctx, query, seq.sequence); err != nil {
+			return fmt.Errorf("failed to reset sequence %s: %w", seq.sequence, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// fixtureSchema is the schema the fixtures are loaded into.
+const fixtureSchema = `
+	CREATE TYPE mood AS ENUM ('sad', 'happy');
+	CREATE TABLE users (
+		id SERIAL PRIMARY KEY,
+		email TEXT NOT NULL,
+		mood mood,
+		tags TEXT[],
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE orders (
+		id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users (id),
+		total NUMERIC(10, 2) NOT NULL,
+		details JSONB
+	);
+	CREATE TABLE order_notes (
+		order_id BIGINT NOT NULL REFERENCES orders (id),
+		note TEXT
+	);
+`
+
+// fixtureFiles are named so that alphabetical order would violate
+// the foreign keys.
+var fixtureFiles = fstest.MapFS{
+	"fixtures/order_notes.csv": {Data: []byte("order_id,note\n10,\"first, with comma\"\n10,\n")},
+	"fixtures/orders.yaml": {Data: []byte(`
+- id: 10
+  user_id: 1
+  total: 12.50
+  details: {items: [{sku: A1, qty: 2}]}
+- id: 11
+  user_id: 2
+  total: "0.99"
+`)},
+	"fixtures/users.json": {Data: []byte(`[
+		{"id": 1, "email": "a@example.com", "mood": "happy", "tags": ["x", "y,z"], "created_at": "2024-01-02T03:04:05Z"},
+		{"id": 2, "email": "b@example.com"}
+	]`)},
+	"fixtures/README.md": {Data: []byte("Not a fixture.")},
+}
+
+func TestFixtureLoader(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Load into a test database", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := conn.ExecContext(ctx, fixtureSchema)
+		c.Assert(err, qt.IsNil)
+
+		loader := pgdbtemplatepgx.NewFSFixtureLoader(fixtureFiles, "fixtures")
+		c.Assert(loader.Load(ctx, conn), qt.IsNil)
+
+		var (
+			mood      string
+			tags      []string
+			createdAt string
+		)
+		err = conn.QueryRowContext(ctx,
+			"SELECT mood::text, tags, to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS') FROM users WHERE id = 1",
+		).Scan(&mood, &tags, &createdAt)
+		c.Assert(err, qt.IsNil)
+		c.Assert(mood, qt.Equals, "happy")
+		c.Assert(tags, qt.DeepEquals, []string{"x", "y,z"})
+		c.Assert(createdAt, qt.Equals, "2024-01-02 03:04:05")
+
+		// Omitted columns get their defaults.
+		var hasCreatedAt bool
+		err = conn.QueryRowContext(ctx, "SELECT created_at IS NOT NULL FROM users WHERE id = 2").Scan(&hasCreatedAt)
+		c.Assert(err, qt.IsNil)
+		c.Assert(hasCreatedAt, qt.IsTrue)
+
+		var total, sku string
+		err = conn.QueryRowContext(ctx,
+			"SELECT total::text, details->'items'->0->>'sku' FROM orders WHERE id = 10",
+		).Scan(&total, &sku)
+		c.Assert(err, qt.IsNil)
+		c.Assert(total, qt.Equals, "12.50")
+		c.Assert(sku, qt.Equals, "A1")
+
+		var notes, nullNotes int
+		err = conn.QueryRowContext(ctx,
+			"SELECT COUNT(*), COUNT(*) FILTER (WHERE note IS NULL) FROM order_notes",
+		).Scan(&notes, &nullNotes)
+		c.Assert(err, qt.IsNil)
+		c.Assert(notes, qt.Equals, 2)
+		c.Assert(nullNotes, qt.Equals, 1)
+
+		// Sequences are moved past the loaded values.
+		var userID, orderID int
+		err = conn.QueryRowContext(ctx, "INSERT INTO users (email) VALUES ('c@example.com') RETURNING id").Scan(&userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(userID, qt.Equals, 3)
+		err = conn.QueryRowContext(ctx, "INSERT INTO orders (user_id, total) VALUES (1, 1) RETURNING id").Scan(&orderID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(orderID, qt.Equals, 12)
+	})
+
+	c.Run("Seed the template", func(c *qt.C) {
+		c.Parallel()
+		migrations := writeMigrations(c, map[string]string{"001_schema.sql": fixtureSchema})
+		runner := pgdbtemplatepgx.SeedAfterMigrations(
+			pgdbtemplatepgx.NewMigrationRunner([]string{migrations}, nil),
+			pgdbtemplatepgx.NewFSFixtureLoader(fixtureFiles, "fixtures/users.json", "fixtures/orders.yaml"),
+		)
+		tm := newTestTemplateManager(c, runner)
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		conn, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() {
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+		}()
+
+		var users, orders int
+		err = conn.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM users), (SELECT COUNT(*) FROM orders)").Scan(&users, &orders)
+		c.Assert(err, qt.IsNil)
+		c.Assert(users, qt.Equals, 2)
+		c.Assert(orders, qt.Equals, 2)
+	})
+
+	c.Run("CSV NULL marker", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		notes := func() []*string {
+			var notes []*string
+			err := conn.QueryRowContext(ctx, "SELECT array_agg(note ORDER BY id) FROM notes").Scan(&notes)
+			c.Assert(err, qt.IsNil)
+			return notes
+		}
+		ptr := func(s string) *string { return &s }
+		files := fstest.MapFS{"notes.csv": {Data: []byte("id,note\n1,\n2,\"\"\n3,\\N\n4,\"\\N\"\n")}}
+
+		_, err := conn.ExecContext(ctx, "CREATE TABLE notes (id INT PRIMARY KEY, note TEXT)")
+		c.Assert(err, qt.IsNil)
+		c.Assert(pgdbtemplatepgx.NewFSFixtureLoader(files, "notes.csv").Load(ctx, conn), qt.IsNil)
+		c.Assert(notes(), qt.DeepEquals, []*string{nil, ptr(""), ptr(`\N`), ptr(`\N`)})
+
+		_, err = conn.ExecContext(ctx, "TRUNCATE notes")
+		c.Assert(err, qt.IsNil)
+		loader := pgdbtemplatepgx.NewFSFixtureLoader(files, "notes.csv").WithCSVNull(`\N`)
+		c.Assert(loader.Load(ctx, conn), qt.IsNil)
+		c.Assert(notes(), qt.DeepEquals, []*string{ptr(""), ptr(""), nil, ptr(`\N`)})
+	})
+
+	c.Run("Errors", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		tests := []struct {
+			files   fstest.MapFS
+			pattern string
+		}{{
+			files:   fstest.MapFS{"missing_table.csv": {Data: []byte("id\n1\n")}},
+			pattern: `table missing_table of fixture "missing_table.csv" does not exist`,
+		}, {
+			files:   fstest.MapFS{"test_table.csv": {Data: []byte("nope\n1\n")}},
+			pattern: `failed to load fixture "test_table.csv": record 1: table test_table has no column "nope"`,
+		}, {
+			files:   fstest.MapFS{"test_table.csv": {Data: []byte("id,name\nx,a\n")}},
+			pattern: `failed to load fixture "test_table.csv": record 1, column id: invalid integer value "x": .*`,
+		}, {
+			files:   fstest.MapFS{"test_table.json": {Data: []byte(`{"id": 1}`)}},
+			pattern: `failed to parse fixture file "test_table.json": .*`,
+		}}
+		for _, test := range tests {
+			loader := pgdbtemplatepgx.NewFSFixtureLoader(test.files, ".")
+			c.Assert(loader.Load(ctx, conn), qt.ErrorMatches, test.pattern)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// globalForkCounter is a global atomic counter for unique fork
+// database names across all providers.
+var globalForkCounter int64
+
+// Fork creates a sibling of the database dbName that starts from its exact
+// current state, and returns a connection to the new database.
+//
+// The fork is cloned with dbName as its template. Provider-owned pools for
+// dbName are closed while cloning and reopened afterwards, so connections
+// handed out for dbName keep working, but any connection acquired from
+// their pools must be released before calling Fork, which fails otherwise.
+// If the server still sees the source as being accessed, only backends
+// opened by this provider are terminated.
+//
+// Forks are dropped by ReleaseFork or Shutdown.
+func (p *ConnectionProvider) Fork(ctx context.Context, dbName string) (_ *DatabaseConnection, err error) {
+	if dbName == p.adminDBName {
+		return nil, fmt.Errorf("admin database %q cannot be used as a source", dbName)
+	}
+
+	forkName := fmt.Sprintf("fork_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalForkCounter, 1))
+	err = p.withDatabaseDrained(ctx, dbName, func(db *drainedDatabase) error {
+		query := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+			pgx.Identifier{forkName}.Sanitize(), pgx.Identifier{dbName}.Sanitize())
+		return db.exec(ctx, query)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fork database %q: %w", dbName, err)
+	}
+
+	// Drop the fork if connecting to it fails.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if dropErr := p.dropDatabase(ctx, forkName); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+	}()
+
+	conn, err := p.connect(ctx, poolKey{database: forkName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to fork: %w", err)
+	}
+
+	p.forksMu.Lock()
+	p.forks[forkName] = struct{}{}
+	p.forksMu.Unlock()
+	return conn, nil
+}
+
+// ReleaseFork closes the provider's pools for a fork created by Fork
+// and drops it.
+//
+// Connections to the fork stop working.
+func (p *ConnectionProvider) ReleaseFork(ctx context.Context, forkName string) error {
+	p.forksMu.Lock()
+	_, exists := p.forks[forkName]
+	p.forksMu.Unlock()
+	if !exists {
+		return fmt.Errorf("unknown fork %q", forkName)
+	}
+
+	if err := p.dropDatabase(ctx, forkName); err != nil {
+		return err
+	}
+
+	p.forksMu.Lock()
+	delete(p.forks, forkName)
+	p.forksMu.Unlock()
+	return nil
+}
+
+// dropForks drops all forks tracked by the provider.
+func (p *ConnectionProvider) dropForks(ctx context.Context) (errs error) {
+	p.forksMu.Lock()
+	forkNames := make([]string, 0, len(p.forks))
+	for forkName := range p.forks {
+		forkNames = append(forkNames, forkName)
+	}
+	p.forksMu.Unlock()
+
+	for _, forkName := range forkNames {
+		if err := p.ReleaseFork(ctx, forkName); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,140 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestFork(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Fork starts from the source state and diverges", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('mid_test')")
+		c.Assert(err, qt.IsNil)
+
+		fork, err := provider.Fork(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(fork.DatabaseName(), qt.Not(qt.Equals), dbName)
+
+		// Both databases start from the same state.
+		var sourceCount, forkCount int
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&sourceCount)
+		c.Assert(err, qt.IsNil)
+		err = fork.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&forkCount)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sourceCount, qt.Equals, 3)
+		c.Assert(forkCount, qt.Equals, 3)
+
+		// And diverge afterwards.
+		_, err = fork.ExecContext(ctx, "DELETE FROM test_table")
+		c.Assert(err, qt.IsNil)
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&sourceCount)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sourceCount, qt.Equals, 3)
+	})
+
+	c.Run("ReleaseFork drops the fork", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		fork, err := provider.Fork(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		forkName := fork.DatabaseName()
+
+		// The fork connection is still open; ReleaseFork closes it.
+		c.Assert(provider.ReleaseFork(ctx, forkName), qt.IsNil)
+		c.Assert(fork.Close(), qt.IsNil)
+
+		var exists bool
+		err = conn.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", forkName,
+		).Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsFalse)
+
+		c.Assert(provider.ReleaseFork(ctx, forkName), qt.ErrorMatches, "unknown fork .*")
+	})
+
+	c.Run("Acquired connections are not waited for", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		acquired, err := conn.(*pgdbtemplatepgx.DatabaseConnection).Pool.Acquire(ctx)
+		c.Assert(err, qt.IsNil)
+		_, err = provider.Fork(ctx, dbName)
+		c.Assert(err, qt.ErrorMatches, `failed to fork database "`+dbName+`": 1 connections to database "`+dbName+`" are still acquired`)
+
+		// The provider is still usable while the connection is held.
+		var count int
+		err = acquired.QueryRow(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+		other, err := provider.Connect(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(other.Close(), qt.IsNil)
+		acquired.Release()
+
+		fork, err := provider.Fork(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		forkName := fork.DatabaseName()
+		acquired, err = fork.Pool.Acquire(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(provider.ReleaseFork(ctx, forkName), qt.ErrorMatches, `failed to drop database "`+forkName+`": 1 connections to database "`+forkName+`" are still acquired`)
+		acquired.Release()
+		c.Assert(provider.ReleaseFork(ctx, forkName), qt.IsNil)
+	})
+
+	c.Run("Foreign sessions are not terminated", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		// A session opened outside of the provider keeps the source busy.
+		otherProvider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer otherProvider.Close()
+		foreign, err := otherProvider.Connect(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(foreign.Close(), qt.IsNil) }()
+
+		_, err = provider.Fork(ctx, dbName)
+		c.Assert(err, qt.ErrorMatches, ".*is being accessed by other users.*")
+
+		// The foreign session survived.
+		var value int
+		err = foreign.QueryRowContext(ctx, "SELECT 1").Scan(&value)
+		c.Assert(err, qt.IsNil)
+		c.Assert(value, qt.Equals, 1)
+	})
+
+	c.Run("Admin database cannot be forked", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		_, err := provider.Fork(ctx, "postgres")
+		c.Assert(err, qt.ErrorMatches, `admin database "postgres" cannot be used as a source`)
+	})
+}
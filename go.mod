@@ -6,6 +6,7 @@ require (
 	github.com/andrei-polukhin/pgdbtemplate v1.0.3
 	github.com/frankban/quicktest v1.14.6
 	github.com/jackc/pgx/v5 v5.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,189 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Listener receives PostgreSQL notifications on a dedicated connection
+// and buffers them until a test expects them.
+type Listener struct {
+	ref      *DatabaseConnection
+	poolConn *pgxpool.Conn
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu       sync.Mutex
+	received []*pgconn.Notification // Not yet expected.
+	changed  chan struct{}          // Closed when received or err changes.
+	err      error                  // Set when receiving failed.
+}
+
+// Listen subscribes to channels on a dedicated connection to the database
+// behind conn, which must have been created by this provider, and buffers
+// the notifications sent to them until Close:
+//
+//	listener, err := provider.Listen(ctx, testDB, "orders")
+//	...
+//	defer listener.Close()
+//
+//	placeOrder(ctx, testDB)
+//	n, err := listener.Expect(ctx, "orders", func(payload string) bool {
+//		return strings.Contains(payload, `"status":"placed"`)
+//	})
+//
+// The connection is held from the pool of conn until Close, which also
+// releases the listener's reference to the pool. Listeners must be closed
+// before calling Checkpoint or Restore on their database.
+func (p *ConnectionProvider) Listen(ctx context.Context, conn pgdbtemplate.DatabaseConnection, channels ...string) (_ *Listener, err error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok || pgxConn.provider != p {
+		return nil, fmt.Errorf("connection was not created by this provider")
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("at least one channel is required")
+	}
+
+	ref, err := p.connect(ctx, pgxConn.key())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = ref.Close()
+		}
+	}()
+
+	poolConn, err := ref.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	for _, channel := range channels {
+		sql := "LISTEN " + pgx.Identifier{channel}.Sanitize()
+		if _, err := execUntraced(ctx, poolConn.Conn(), sql); err != nil {
+			releaseListenerConn(poolConn)
+			return nil, fmt.Errorf("failed to listen on channel %q: %w", channel, err)
+		}
+	}
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		ref:      ref,
+		poolConn: poolConn,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+	go l.receive(loopCtx)
+	return l, nil
+}
+
+// receive buffers notifications until ctx is canceled
+// or the connection fails.
+func (l *Listener) receive(ctx context.Context) {
+	defer close(l.done)
+	for {
+		n, err := l.poolConn.Conn().WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return // Closed.
+		}
+
+		l.mu.Lock()
+		if err != nil {
+			l.err = fmt.Errorf("failed to receive notification: %w", err)
+		} else {
+			l.received = append(l.received, n)
+		}
+		close(l.changed)
+		l.changed = make(chan struct{})
+		l.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Expect returns the first buffered or future notification on channel
+// whose payload satisfies predicate, removing it from the buffer. A nil
+// predicate accepts any payload.
+//
+// Expect waits until ctx is done, e.g. by its timeout, and then returns
+// an error listing the notifications received on channel meanwhile.
+func (l *Listener) Expect(ctx context.Context, channel string, predicate func(payload string) bool) (*pgconn.Notification, error) {
+	for {
+		l.mu.Lock()
+		for i, n := range l.received {
+			if n.Channel == channel && (predicate == nil || predicate(n.Payload)) {
+				l.received = append(l.received[:i:i], l.received[i+1:]...)
+				l.mu.Unlock()
+				return n, nil
+			}
+		}
+		changed, receiveErr := l.changed, l.err
+		l.mu.Unlock()
+
+		if receiveErr != nil {
+			return nil, receiveErr
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no matching notification on channel %q (%s): %w",
+				channel, l.describeReceived(channel), ctx.Err())
+		}
+	}
+}
+
+// Received returns the buffered notifications that were not expected yet.
+func (l *Listener) Received() []*pgconn.Notification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*pgconn.Notification(nil), l.received...)
+}
+
+// describeReceived lists the buffered payloads of channel.
+func (l *Listener) describeReceived(channel string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var payloads []string
+	for _, n := range l.received {
+		if n.Channel == channel {
+			payloads = append(payloads, fmt.Sprintf("%q", n.Payload))
+		}
+	}
+	if len(payloads) == 0 {
+		return "none received"
+	}
+	return "received " + strings.Join(payloads, ", ")
+}
+
+// Close unsubscribes from all channels, returns the connection to the
+// pool and releases the listener's reference to it. Buffered
+// notifications are discarded.
+func (l *Listener) Close() error {
+	l.cancel()
+	<-l.done
+
+	releaseListenerConn(l.poolConn)
+	return l.ref.Close()
+}
+
+// releaseListenerConn unsubscribes poolConn from all channels and releases
+// it, closing it instead if that fails, so that no subscription leaks to
+// other users of the pool.
+func releaseListenerConn(poolConn *pgxpool.Conn) {
+	ctx := context.Background()
+	if _, err := execUntraced(ctx, poolConn.Conn(), "UNLISTEN *"); err != nil {
+		_ = poolConn.Conn().Close(ctx)
+	}
+	poolConn.Release()
+}
@@ -0,0 +1,134 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Expect buffered and future notifications", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithQueryCapture())
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		pgxConn := conn.(*pgdbtemplatepgx.DatabaseConnection)
+		pgxConn.ResetCapturedQueries()
+
+		listener, err := provider.Listen(ctx, conn, "orders", "Audit Log")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(listener.Close(), qt.IsNil) }()
+
+		// Subscribing does not show up in captured queries.
+		c.Assert(pgxConn.CapturedQueries(), qt.HasLen, 0)
+
+		for _, payload := range []string{`{"id":1,"status":"new"}`, `{"id":2,"status":"placed"}`} {
+			_, err = conn.ExecContext(ctx, "SELECT pg_notify('orders', $1)", payload)
+			c.Assert(err, qt.IsNil)
+		}
+		_, err = conn.ExecContext(ctx, "SELECT pg_notify('Audit Log', 'order placed')")
+		c.Assert(err, qt.IsNil)
+
+		expectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		n, err := listener.Expect(expectCtx, "orders", func(payload string) bool {
+			return strings.Contains(payload, `"status":"placed"`)
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(n.Payload, qt.Equals, `{"id":2,"status":"placed"}`)
+
+		n, err = listener.Expect(expectCtx, "Audit Log", nil)
+		c.Assert(err, qt.IsNil)
+		c.Assert(n.Payload, qt.Equals, "order placed")
+
+		// Notifications sent after Expect starts waiting are received.
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_, _ = conn.ExecContext(ctx, "SELECT pg_notify('orders', 'late')")
+		}()
+		n, err = listener.Expect(expectCtx, "orders", func(payload string) bool { return payload == "late" })
+		c.Assert(err, qt.IsNil)
+		c.Assert(n.Payload, qt.Equals, "late")
+
+		received := listener.Received()
+		c.Assert(received, qt.HasLen, 1)
+		c.Assert(received[0].Payload, qt.Equals, `{"id":1,"status":"new"}`)
+	})
+
+	c.Run("Expect times out", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		listener, err := provider.Listen(ctx, conn, "orders")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(listener.Close(), qt.IsNil) }()
+
+		_, err = conn.ExecContext(ctx, "SELECT pg_notify('orders', 'new')")
+		c.Assert(err, qt.IsNil)
+
+		expectCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		_, err = listener.Expect(expectCtx, "orders", func(payload string) bool { return payload == "placed" })
+		c.Assert(err, qt.ErrorMatches, `no matching notification on channel "orders" \(received "new"\): context deadline exceeded`)
+		c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+	})
+
+	c.Run("Close releases the connection", func(c *qt.C) {
+		c.Parallel()
+		// A single connection shows that the session stops listening.
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithMaxConns(1))
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		listener, err := provider.Listen(ctx, conn, "orders")
+		c.Assert(err, qt.IsNil)
+
+		// The listener keeps the pool open after the connection is closed.
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(listener.Close(), qt.IsNil)
+
+		conn, err = provider.Connect(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		listener, err = provider.Listen(ctx, conn, "orders")
+		c.Assert(err, qt.IsNil)
+		c.Assert(listener.Close(), qt.IsNil)
+
+		var channels int
+		c.Assert(conn.QueryRowContext(ctx, "SELECT count(*) FROM pg_listening_channels()").Scan(&channels), qt.IsNil)
+		c.Assert(channels, qt.Equals, 0)
+	})
+
+	c.Run("Foreign connection", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+		other := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(other.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, other)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := provider.Listen(ctx, conn, "orders")
+		c.Assert(err, qt.ErrorMatches, "connection was not created by this provider")
+	})
+}
@@ -0,0 +1,349 @@
+package pgdbtemplatepgx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MigrationFormat is the layout of migration files.
+type MigrationFormat int
+
+const (
+	// NativeMigrationFormat runs every .sql file as one migration.
+	// A file containing the line "-- +notransaction" runs outside
+	// of a transaction.
+	NativeMigrationFormat MigrationFormat = iota
+
+	// GolangMigrateFormat reads golang-migrate files named
+	// "<version>_<title>.up.sql" and "<version>_<title>.down.sql".
+	//
+	// golang-migrate has no no-transaction annotation, so the
+	// "-- +notransaction" directive of NativeMigrationFormat is honored.
+	GolangMigrateFormat
+
+	// GooseMigrationFormat reads goose SQL files with "-- +goose Up" and
+	// "-- +goose Down" sections. "-- +goose NO TRANSACTION" and
+	// "-- +goose StatementBegin"/"-- +goose StatementEnd" are honored.
+	GooseMigrationFormat
+
+	// DbmateMigrationFormat reads dbmate files with "-- migrate:up" and
+	// "-- migrate:down" sections. The "transaction:false" option is honored.
+	DbmateMigrationFormat
+)
+
+// String returns the name of the format.
+func (f MigrationFormat) String() string {
+	switch f {
+	case NativeMigrationFormat:
+		return "native"
+	case GolangMigrateFormat:
+		return "golang-migrate"
+	case GooseMigrationFormat:
+		return "goose"
+	case DbmateMigrationFormat:
+		return "dbmate"
+	}
+	return fmt.Sprintf("MigrationFormat(%d)", int(f))
+}
+
+// migration is a single versioned migration.
+type migration struct {
+	version string // Numeric version, see migrationVersion.
+	name    string // Base name of the up file, for reports.
+	up      migrationScript
+	down    *migrationScript // Nil if the migration cannot be reverted.
+}
+
+// migrationScript is SQL run in one direction of a migration.
+type migrationScript struct {
+	path          string
+	content       string // Entire file, for locating errors.
+	sql           string // Part of content to run.
+	offset        int    // Byte offset of sql within content.
+	noTransaction bool
+	// statements are the statements of sql run outside of a transaction,
+	// with offsets relative to sql. If nil, sql is split at semicolons.
+	statements []sqlStatement
+}
+
+// golangMigrateFileName matches golang-migrate file names.
+var golangMigrateFileName = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+
+// parseMigrations parses files laid out in format into migrations,
+// keeping the order of files.
+func parseMigrations(format MigrationFormat, files []migrationFile) ([]migration, error) {
+	switch format {
+	case NativeMigrationFormat:
+		migrations := make([]migration, 0, len(files))
+		for _, file := range files {
+			content := string(file.content)
+			migrations = append(migrations, migration{
+				version: migrationVersion(file.name),
+				name:    file.name,
+				up: migrationScript{
+					path:          file.path,
+					content:       content,
+					sql:           content,
+					noTransaction: hasNoTransactionDirective(content),
+				},
+			})
+		}
+		return migrations, nil
+	case GolangMigrateFormat:
+		return parseGolangMigrateMigrations(files)
+	case GooseMigrationFormat, DbmateMigrationFormat:
+		migrations := make([]migration, 0, len(files))
+		for _, file := range files {
+			parse := parseGooseMigration
+			if format == DbmateMigrationFormat {
+				parse = parseDbmateMigration
+			}
+			m, err := parse(file)
+			if err != nil {
+				return nil, err
+			}
+			migrations = append(migrations, m)
+		}
+		return migrations, nil
+	}
+	return nil, fmt.Errorf("unknown migration format %v", format)
+}
+
+// parseGolangMigrateMigrations pairs golang-migrate up and down files.
+func parseGolangMigrateMigrations(files []migrationFile) ([]migration, error) {
+	var (
+		migrations []migration
+		byVersion  = make(map[string]int)
+		downs      = make(map[string]*migrationScript)
+	)
+	for _, file := range files {
+		match := golangMigrateFileName.FindStringSubmatch(file.name)
+		if match == nil {
+			return nil, fmt.Errorf("invalid golang-migrate migration file name %q", file.name)
+		}
+		version := migrationVersion(file.name)
+		content := string(file.content)
+		script := migrationScript{
+			path:          file.path,
+			content:       content,
+			sql:           content,
+			noTransaction: hasNoTransactionDirective(content),
+		}
+
+		if match[2] == "down" {
+			if _, ok := downs[version]; ok {
+				return nil, fmt.Errorf("duplicate down migration for version %s", match[1])
+			}
+			downs[version] = &script
+			continue
+		}
+		if _, ok := byVersion[version]; ok {
+			return nil, fmt.Errorf("duplicate up migration for version %s", match[1])
+		}
+		byVersion[version] = len(migrations)
+		migrations = append(migrations, migration{version: version, name: file.name, up: script})
+	}
+
+	for version, down := range downs {
+		i, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("down migration %q has no up migration", down.path)
+		}
+		migrations[i].down = down
+	}
+	return migrations, nil
+}
+
+// parseGooseMigration parses the sections of a goose SQL file.
+func parseGooseMigration(file migrationFile) (migration, error) {
+	content := string(file.content)
+	m := migration{version: migrationVersion(file.name), name: file.name}
+
+	var (
+		noTransaction bool
+		sections      = make(map[string][2]int) // Section name to its bounds.
+		current       string
+		blocks        = make(map[string][][2]int) // StatementBegin/End bounds.
+		blockStart    = -1
+	)
+	endSection := func(end int) {
+		if current != "" {
+			bounds := sections[current]
+			bounds[1] = end
+			sections[current] = bounds
+		}
+	}
+	err := forEachLine(content, func(line string, start, next int) error {
+		annotation, ok := strings.CutPrefix(strings.TrimSpace(line), "-- +goose ")
+		if !ok {
+			return nil
+		}
+		switch annotation = strings.TrimSpace(annotation); strings.ToUpper(annotation) {
+		case "UP", "DOWN":
+			if blockStart >= 0 {
+				return fmt.Errorf("missing -- +goose StatementEnd before -- +goose %s", annotation)
+			}
+			endSection(start)
+			current = strings.ToUpper(annotation)
+			if _, ok := sections[current]; ok {
+				return fmt.Errorf("duplicate -- +goose %s annotation", annotation)
+			}
+			sections[current] = [2]int{next, len(content)}
+		case "NO TRANSACTION":
+			noTransaction = true
+		case "STATEMENTBEGIN":
+			if current == "" || blockStart >= 0 {
+				return fmt.Errorf("unexpected -- +goose StatementBegin")
+			}
+			blockStart = next
+		case "STATEMENTEND":
+			if blockStart < 0 {
+				return fmt.Errorf("unexpected -- +goose StatementEnd")
+			}
+			blocks[current] = append(blocks[current], [2]int{blockStart, start})
+			blockStart = -1
+		}
+		return nil
+	})
+	if err == nil && blockStart >= 0 {
+		err = fmt.Errorf("missing -- +goose StatementEnd")
+	}
+	if err != nil {
+		return migration{}, fmt.Errorf("failed to parse goose migration %q: %w", file.path, err)
+	}
+	endSection(len(content))
+
+	up, ok := sections["UP"]
+	if !ok {
+		return migration{}, fmt.Errorf("failed to parse goose migration %q: missing -- +goose Up annotation", file.path)
+	}
+	m.up = gooseScript(file.path, content, up, blocks["UP"], noTransaction)
+	if down, ok := sections["DOWN"]; ok {
+		script := gooseScript(file.path, content, down, blocks["DOWN"], noTransaction)
+		m.down = &script
+	}
+	return m, nil
+}
+
+// gooseScript creates the script of a goose section, splitting it into
+// statements at StatementBegin/StatementEnd blocks and semicolons.
+func gooseScript(path, content string, bounds [2]int, blocks [][2]int, noTransaction bool) migrationScript {
+	script := migrationScript{
+		path:          path,
+		content:       content,
+		sql:           content[bounds[0]:bounds[1]],
+		offset:        bounds[0],
+		noTransaction: noTransaction,
+		statements:    []sqlStatement{},
+	}
+	appendSplit := func(start, end int) {
+		for _, stmt := range splitStatements(content[start:end]) {
+			stmt.offset += start - bounds[0]
+			script.statements = append(script.statements, stmt)
+		}
+	}
+
+	chunkStart := bounds[0]
+	for _, block := range blocks {
+		appendSplit(chunkStart, block[0])
+		script.statements = append(script.statements, sqlStatement{
+			text:   content[block[0]:block[1]],
+			offset: block[0] - bounds[0],
+		})
+		// Skip the StatementEnd line.
+		chunkStart = block[1]
+		if i := strings.IndexByte(content[chunkStart:], '\n'); i >= 0 {
+			chunkStart += i + 1
+		} else {
+			chunkStart = len(content)
+		}
+	}
+	if chunkStart < bounds[1] {
+		appendSplit(chunkStart, bounds[1])
+	}
+	return script
+}
+
+// parseDbmateMigration parses the sections of a dbmate migration file.
+func parseDbmateMigration(file migrationFile) (migration, error) {
+	content := string(file.content)
+	m := migration{version: migrationVersion(file.name), name: file.name}
+
+	type section struct {
+		bounds        [2]int
+		noTransaction bool
+	}
+	var (
+		sections = make(map[string]*section)
+		current  *section
+	)
+	err := forEachLine(content, func(line string, start, next int) error {
+		annotation, ok := strings.CutPrefix(strings.TrimSpace(line), "-- migrate:")
+		if !ok {
+			return nil
+		}
+		fields := strings.Fields(annotation)
+		if len(fields) == 0 || (fields[0] != "up" && fields[0] != "down") {
+			return fmt.Errorf("unknown annotation %q", strings.TrimSpace(line))
+		}
+		if _, ok := sections[fields[0]]; ok {
+			return fmt.Errorf("duplicate -- migrate:%s annotation", fields[0])
+		}
+		if current != nil {
+			current.bounds[1] = start
+		}
+		current = &section{bounds: [2]int{next, len(content)}}
+		for _, option := range fields[1:] {
+			switch option {
+			case "transaction:false":
+				current.noTransaction = true
+			case "transaction:true":
+			default:
+				return fmt.Errorf("unknown option %q", option)
+			}
+		}
+		sections[fields[0]] = current
+		return nil
+	})
+	if err != nil {
+		return migration{}, fmt.Errorf("failed to parse dbmate migration %q: %w", file.path, err)
+	}
+
+	script := func(s *section) migrationScript {
+		return migrationScript{
+			path:          file.path,
+			content:       content,
+			sql:           content[s.bounds[0]:s.bounds[1]],
+			offset:        s.bounds[0],
+			noTransaction: s.noTransaction,
+		}
+	}
+	up, ok := sections["up"]
+	if !ok {
+		return migration{}, fmt.Errorf("failed to parse dbmate migration %q: missing -- migrate:up annotation", file.path)
+	}
+	m.up = script(up)
+	if down, ok := sections["down"]; ok {
+		downScript := script(down)
+		m.down = &downScript
+	}
+	return m, nil
+}
+
+// forEachLine calls fn with every line of content without its line
+// terminator, the byte offset of the line and the offset of the next line.
+func forEachLine(content string, fn func(line string, start, next int) error) error {
+	for start := 0; start < len(content); {
+		next := len(content)
+		if i := strings.IndexByte(content[start:], '\n'); i >= 0 {
+			next = start + i + 1
+		}
+		line := strings.TrimRight(content[start:next], "\r\n")
+		if err := fn(line, start, next); err != nil {
+			return err
+		}
+		start = next
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestMigrationFormats(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Every layout creates the same schema, including a non-transactional
+	// step, and records the order in which migrations were applied.
+	tests := []struct {
+		name   string
+		format pgdbtemplatepgx.MigrationFormat
+		files  fstest.MapFS
+	}{{
+		name:   "golang-migrate",
+		format: pgdbtemplatepgx.GolangMigrateFormat,
+		files: fstest.MapFS{
+			"1_create.up.sql":   {Data: []byte("CREATE TABLE steps (step INT);\nINSERT INTO steps VALUES (1);")},
+			"1_create.down.sql": {Data: []byte("DROP TABLE steps;")},
+			"2_index.up.sql":    {Data: []byte("-- +notransaction\nCREATE INDEX CONCURRENTLY steps_idx ON steps (step);\nINSERT INTO steps VALUES (2);")},
+			"10_insert.up.sql":  {Data: []byte("INSERT INTO steps VALUES (10);")},
+		},
+	}, {
+		name:   "goose",
+		format: pgdbtemplatepgx.GooseMigrationFormat,
+		files: fstest.MapFS{
+			"00001_create.sql": {Data: []byte(`-- +goose Up
+CREATE TABLE steps (step INT);
+INSERT INTO steps VALUES (1);
+
+-- +goose Down
+DROP TABLE steps;
+`)},
+			"00002_index.sql": {Data: []byte(`-- +goose NO TRANSACTION
+-- +goose Up
+CREATE INDEX CONCURRENTLY steps_idx ON steps (step);
+-- +goose StatementBegin
+DO $$ BEGIN INSERT INTO steps VALUES (2); END $$;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP INDEX CONCURRENTLY steps_idx;
+`)},
+			"00010_insert.sql": {Data: []byte("-- +goose Up\nINSERT INTO steps VALUES (10);\n")},
+		},
+	}, {
+		name:   "dbmate",
+		format: pgdbtemplatepgx.DbmateMigrationFormat,
+		files: fstest.MapFS{
+			"20240101000001_create.sql": {Data: []byte(`-- migrate:up
+CREATE TABLE steps (step INT);
+INSERT INTO steps VALUES (1);
+
+-- migrate:down
+DROP TABLE steps;
+`)},
+			"20240101000002_index.sql": {Data: []byte(`-- migrate:up transaction:false
+CREATE INDEX CONCURRENTLY steps_idx ON steps (step);
+INSERT INTO steps VALUES (2);
+
+-- migrate:down
+DROP INDEX steps_idx;
+`)},
+			"20240101000010_insert.sql": {Data: []byte("-- migrate:up\nINSERT INTO steps VALUES (10);\n-- migrate:down\n")},
+		},
+	}}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *qt.C) {
+			c.Parallel()
+			runner := pgdbtemplatepgx.NewFSMigrationRunner(test.files, []string{"."}, nil,
+				pgdbtemplatepgx.WithMigrationFormat(test.format))
+			tm := newTestTemplateManager(c, runner)
+			c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+			conn, dbName, err := tm.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+			defer func() {
+				c.Assert(conn.Close(), qt.IsNil)
+				c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+			}()
+
+			var steps []int32
+			err = conn.QueryRowContext(ctx, "SELECT array_agg(step ORDER BY ctid) FROM steps").Scan(&steps)
+			c.Assert(err, qt.IsNil)
+			c.Assert(steps, qt.DeepEquals, []int32{1, 2, 10})
+
+			var indexed bool
+			err = conn.QueryRowContext(ctx,
+				"SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'steps_idx')",
+			).Scan(&indexed)
+			c.Assert(err, qt.IsNil)
+			c.Assert(indexed, qt.IsTrue)
+		})
+	}
+
+	c.Run("Invalid layouts", func(c *qt.C) {
+		invalid := []struct {
+			format  pgdbtemplatepgx.MigrationFormat
+			files   fstest.MapFS
+			pattern string
+		}{{
+			format:  pgdbtemplatepgx.GolangMigrateFormat,
+			files:   fstest.MapFS{"create.sql": {Data: []byte("SELECT 1;")}},
+			pattern: `invalid golang-migrate migration file name "create.sql"`,
+		}, {
+			format:  pgdbtemplatepgx.GolangMigrateFormat,
+			files:   fstest.MapFS{"1_create.down.sql": {Data: []byte("SELECT 1;")}},
+			pattern: `down migration "1_create.down.sql" has no up migration`,
+		}, {
+			format:  pgdbtemplatepgx.GooseMigrationFormat,
+			files:   fstest.MapFS{"1_create.sql": {Data: []byte("SELECT 1;")}},
+			pattern: `failed to parse goose migration "1_create.sql": missing -- \+goose Up annotation`,
+		}, {
+			format:  pgdbtemplatepgx.GooseMigrationFormat,
+			files:   fstest.MapFS{"1_create.sql": {Data: []byte("-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n")}},
+			pattern: `failed to parse goose migration "1_create.sql": missing -- \+goose StatementEnd`,
+		}, {
+			format:  pgdbtemplatepgx.DbmateMigrationFormat,
+			files:   fstest.MapFS{"1_create.sql": {Data: []byte("-- migrate:up transaction:maybe\nSELECT 1;\n")}},
+			pattern: `failed to parse dbmate migration "1_create.sql": unknown option "transaction:maybe"`,
+		}}
+		for _, test := range invalid {
+			runner := pgdbtemplatepgx.NewFSMigrationRunner(test.files, []string{"."}, nil,
+				pgdbtemplatepgx.WithMigrationFormat(test.format))
+			tm := newTestTemplateManager(c, runner)
+			c.Assert(tm.Initialize(ctx), qt.ErrorMatches, ".*"+test.pattern)
+		}
+	})
+
+	c.Run("Errors are located within the file", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		files := fstest.MapFS{
+			"1_broken.sql": {Data: []byte("-- migrate:up\nSELECT 1;\nSELECT nope;\n-- migrate:down\n")},
+		}
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(files, []string{"."}, nil,
+			pgdbtemplatepgx.WithMigrationFormat(pgdbtemplatepgx.DbmateMigrationFormat))
+		err := runner.RunMigrations(ctx, conn)
+
+		var migrationErr *pgdbtemplatepgx.MigrationError
+		c.Assert(errors.As(err, &migrationErr), qt.IsTrue)
+		c.Assert(migrationErr.File, qt.Equals, "1_broken.sql")
+		c.Assert(migrationErr.Line, qt.Equals, 3)
+		c.Assert(migrationErr.Column, qt.Equals, 8)
+	})
+}
@@ -0,0 +1,244 @@
+package pgdbtemplatepgx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// noTransactionDirective marks a migration file that must not be wrapped
+// in a transaction, e.g. because it runs CREATE INDEX CONCURRENTLY.
+const noTransactionDirective = "-- +notransaction"
+
+// MigrationError describes a failed migration file.
+type MigrationError struct {
+	// File is the path of the failed migration file.
+	File string
+	// Line and Column locate the error within File.
+	//
+	// Both are zero if the server did not report a position.
+	Line, Column int
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *MigrationError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("migration %s failed: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("migration %s failed at line %d, column %d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationRunner runs SQL migration files through pgx.
+//
+// Unlike pgdbtemplate.FileMigrationRunner, it sends every file through the
+// simple protocol, so files may contain several statements, and wraps every
+// file in its own transaction. Files containing the line
+//
+//	-- +notransaction
+//
+// are instead executed statement by statement outside of a transaction,
+// as required by CREATE INDEX CONCURRENTLY or ALTER TYPE ... ADD VALUE.
+//
+// All migrations run on a single connection of the pool, so session state
+// set by one file is visible to the following ones. Failures are reported
+// as *MigrationError with the line and column reported by the server.
+//
+// Files laid out for golang-migrate, goose or dbmate are supported
+// through WithMigrationFormat.
+type MigrationRunner struct {
+	source         migrationSource
+	migrationPaths []string
+	orderingFunc   func([]string) []string
+	format         MigrationFormat
+}
+
+// MigrationRunnerOption configures MigrationRunner.
+type MigrationRunnerOption func(*MigrationRunner)
+
+// WithMigrationFormat sets the layout of the migration files.
+//
+// Only the up migrations are run. For formats other than
+// NativeMigrationFormat, files are ordered by
+// NumericVersionMigrationFilesSorting unless an ordering function is given.
+//
+// If not set, NativeMigrationFormat will be used.
+func WithMigrationFormat(format MigrationFormat) MigrationRunnerOption {
+	return func(r *MigrationRunner) {
+		r.format = format
+	}
+}
+
+// NewMigrationRunner creates a new pgx migration runner.
+//
+// The .sql files of every path are ordered by orderingFunc within each
+// path. Upon the nil function provided, an alphabetical sorting will be used.
+func NewMigrationRunner(paths []string, orderingFunc func([]string) []string, opts ...MigrationRunnerOption) *MigrationRunner {
+	return newMigrationRunner(osMigrationSource, paths, orderingFunc, opts)
+}
+
+// NewFSMigrationRunner creates a new pgx migration runner reading
+// migration files from fsys, e.g. an embed.FS.
+//
+// paths are directories of fsys, e.g. "migrations" or "." for its root.
+// The .sql files of every path are ordered by orderingFunc within each
+// path. Upon the nil function provided, an alphabetical sorting will be used.
+// See also NumericVersionMigrationFilesSorting and
+// ManifestMigrationFilesOrdering.
+func NewFSMigrationRunner(fsys fs.FS, paths []string, orderingFunc func([]string) []string, opts ...MigrationRunnerOption) *MigrationRunner {
+	return newMigrationRunner(fsMigrationSource(fsys), paths, orderingFunc, opts)
+}
+
+func newMigrationRunner(source migrationSource, paths []string, orderingFunc func([]string) []string, opts []MigrationRunnerOption) *MigrationRunner {
+	r := &MigrationRunner{
+		source:         source,
+		migrationPaths: paths,
+		orderingFunc:   orderingFunc,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RunMigrations implements pgdbtemplate.MigrationRunner.RunMigrations.
+//
+// conn must be a *DatabaseConnection.
+func (r *MigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) (err error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok {
+		return fmt.Errorf("MigrationRunner requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+
+	migrations, err := r.migrations()
+	if err != nil {
+		return err
+	}
+
+	poolConn, err := pgxConn.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer poolConn.Release()
+
+	// Migrations may legitimately run longer than
+	// the session timeouts meant for tests.
+	restoreTimeouts, err := liftSessionTimeouts(ctx, poolConn)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, restoreTimeouts()) }()
+
+	for _, m := range migrations {
+		if err := runMigrationScript(ctx, poolConn, m.up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations reads and parses the migrations in the order they are run.
+func (r *MigrationRunner) migrations() ([]migration, error) {
+	orderingFunc := r.orderingFunc
+	if orderingFunc == nil && r.format != NativeMigrationFormat {
+		orderingFunc = NumericVersionMigrationFilesSorting
+	}
+	files, err := r.source.readFiles(r.migrationPaths, orderingFunc)
+	if err != nil {
+		return nil, err
+	}
+	return parseMigrations(r.format, files)
+}
+
+// runMigrationScript runs script on poolConn, reporting failures
+// as *MigrationError.
+func runMigrationScript(ctx context.Context, poolConn *pgxpool.Conn, script migrationScript) error {
+	if err := execMigrationScript(ctx, poolConn, script); err != nil {
+		return newMigrationError(script.path, script.content, err)
+	}
+	return nil
+}
+
+// execMigrationScript runs script on poolConn, either as a single
+// multi-statement query in a transaction or statement by statement.
+func execMigrationScript(ctx context.Context, poolConn *pgxpool.Conn, script migrationScript) error {
+	if script.noTransaction {
+		statements := script.statements
+		if statements == nil {
+			statements = splitStatements(script.sql)
+		}
+		for _, stmt := range statements {
+			if _, err := poolConn.Conn().PgConn().Exec(ctx, stmt.text).ReadAll(); err != nil {
+				return &scriptError{offset: script.offset + stmt.offset, err: err}
+			}
+		}
+		return nil
+	}
+
+	tx, err := poolConn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.Conn().PgConn().Exec(ctx, script.sql).ReadAll(); err != nil {
+		// The original error is more useful than a rollback failure.
+		_ = tx.Rollback(ctx)
+		return &scriptError{offset: script.offset, err: err}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// scriptError is an error of a query starting at offset of a script.
+type scriptError struct {
+	offset int
+	err    error
+}
+
+func (e *scriptError) Error() string { return e.err.Error() }
+func (e *scriptError) Unwrap() error { return e.err }
+
+// newMigrationError wraps err into a *MigrationError, locating
+// the server-reported error position within script.
+func newMigrationError(path, script string, err error) *MigrationError {
+	migrationErr := &MigrationError{File: path, Err: err}
+
+	var (
+		scriptErr *scriptError
+		pgErr     *pgconn.PgError
+	)
+	if errors.As(err, &scriptErr) {
+		migrationErr.Err = scriptErr.err
+		if errors.As(err, &pgErr) {
+			migrationErr.Line, migrationErr.Column = lineColumn(script, scriptErr.offset, pgErr.Position)
+		}
+	}
+	return migrationErr
+}
+
+// hasNoTransactionDirective reports whether script contains
+// the no-transaction directive on a line of its own.
+func hasNoTransactionDirective(script string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	scanner.Buffer(nil, len(script)+1)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == noTransactionDirective {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// templateManagerOption adjusts the configuration of a test template manager.
+type templateManagerOption func(*pgdbtemplate.Config)
+
+// withProvider makes a test template manager connect to the template
+// and test databases through provider.
+func withProvider(provider *pgdbtemplatepgx.ConnectionProvider) templateManagerOption {
+	return func(config *pgdbtemplate.Config) {
+		config.ConnectionProvider = provider
+	}
+}
+
+// withTemplateName makes a test template manager use an existing template.
+func withTemplateName(templateName string) templateManagerOption {
+	return func(config *pgdbtemplate.Config) {
+		config.TemplateName = templateName
+	}
+}
+
+// adminProvider connects to the admin database through admin
+// and to other databases through the embedded provider.
+type adminProvider struct {
+	pgdbtemplate.ConnectionProvider
+	admin       *pgdbtemplatepgx.ConnectionProvider
+	adminDBName string
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.
+func (p adminProvider) Connect(ctx context.Context, dbName string) (pgdbtemplate.DatabaseConnection, error) {
+	if dbName == p.adminDBName {
+		return p.admin.Connect(ctx, dbName)
+	}
+	return p.ConnectionProvider.Connect(ctx, dbName)
+}
+
+// newTestTemplateManager creates a template manager using runner
+// and registers its cleanup with c.
+//
+// The admin database is always reached through a provider of its own,
+// closed once the template manager is cleaned up, so that the cleanup
+// does not depend on a provider passed with withProvider, which tests
+// usually shut down before.
+func newTestTemplateManager(c *qt.C, runner pgdbtemplate.MigrationRunner, opts ...templateManagerOption) *pgdbtemplate.TemplateManager {
+	admin := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	c.Cleanup(admin.Close)
+
+	config := pgdbtemplate.Config{
+		ConnectionProvider: admin,
+		MigrationRunner:    runner,
+		TemplateName:       fmt.Sprintf("pgx_test_template_%d_%d", time.Now().UnixNano(), os.Getpid()),
+		TestDBPrefix:       fmt.Sprintf("pgx_test_%d_%d_", time.Now().UnixNano(), os.Getpid()),
+		AdminDBName:        "postgres",
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.ConnectionProvider != admin {
+		config.ConnectionProvider = adminProvider{
+			ConnectionProvider: config.ConnectionProvider,
+			admin:              admin,
+			adminDBName:        config.AdminDBName,
+		}
+	}
+
+	tm, err := pgdbtemplate.NewTemplateManager(config)
+	c.Assert(err, qt.IsNil)
+	// Cleanups run last in, first out: the template manager
+	// is cleaned up before admin is closed.
+	c.Cleanup(func() { c.Check(tm.Cleanup(context.Background()), qt.IsNil) })
+	return tm
+}
+
+func TestMigrationRunner(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Multi-statement and non-transactional files", func(c *qt.C) {
+		c.Parallel()
+		dir := writeMigrations(c, map[string]string{
+			"001_schema.sql": `
+				CREATE TYPE mood AS ENUM ('sad', 'ok');
+				CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT, mood mood);
+				CREATE FUNCTION greet(n TEXT) RETURNS TEXT AS $$
+					SELECT 'hello; ' || n;
+				$$ LANGUAGE SQL;
+			`,
+			"002_concurrently.sql": `
+				-- +notransaction
+				ALTER TYPE mood ADD VALUE 'happy';
+				CREATE INDEX CONCURRENTLY users_name_idx ON users (name);
+			`,
+			"003_data.sql": `
+				INSERT INTO users (name, mood) VALUES ('a', 'happy'); /* ; */
+				INSERT INTO users (name, mood) VALUES ('b;c', 'ok');
+			`,
+		})
+		tm := newTestTemplateManager(c, pgdbtemplatepgx.NewMigrationRunner([]string{dir}, nil))
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+		conn, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() {
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+		}()
+
+		var count int
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE mood = 'happy'").Scan(&count)
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 1)
+
+		var greeting string
+		err = conn.QueryRowContext(ctx, "SELECT greet('x')").Scan(&greeting)
+		c.Assert(err, qt.IsNil)
+		c.Assert(greeting, qt.Equals, "hello; x")
+
+		var indexed bool
+		err = conn.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'users_name_idx')",
+		).Scan(&indexed)
+		c.Assert(err, qt.IsNil)
+		c.Assert(indexed, qt.IsTrue)
+	})
+
+	c.Run("Failed file is rolled back and located", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		dir := writeMigrations(c, map[string]string{
+			"001_broken.sql": "CREATE TABLE rolled_back (id INT);\n\nSELECT * FROM\n  missing_table;\n",
+		})
+		runner := pgdbtemplatepgx.NewMigrationRunner([]string{dir}, nil)
+		err := runner.RunMigrations(ctx, conn)
+
+		var migrationErr *pgdbtemplatepgx.MigrationError
+		c.Assert(errors.As(err, &migrationErr), qt.IsTrue)
+		c.Assert(migrationErr.File, qt.Equals, filepath.Join(dir, "001_broken.sql"))
+		c.Assert(migrationErr.Line, qt.Equals, 4)
+		c.Assert(migrationErr.Column, qt.Equals, 3)
+		c.Assert(err, qt.ErrorMatches, `migration .*001_broken.sql failed at line 4, column 3: .*missing_table.*`)
+
+		var exists bool
+		err = conn.QueryRowContext(ctx, "SELECT to_regclass('rolled_back') IS NOT NULL").Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsFalse)
+	})
+
+	c.Run("Failed statement without transaction is located", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		dir := writeMigrations(c, map[string]string{
+			"001_broken.sql": "-- +notransaction\nCREATE TABLE kept (id INT);\nSELECT 'a;b', nope FROM kept;\n",
+		})
+		runner := pgdbtemplatepgx.NewMigrationRunner([]string{dir}, nil)
+		err := runner.RunMigrations(ctx, conn)
+
+		var migrationErr *pgdbtemplatepgx.MigrationError
+		c.Assert(errors.As(err, &migrationErr), qt.IsTrue)
+		c.Assert(migrationErr.Line, qt.Equals, 3)
+		c.Assert(migrationErr.Column, qt.Equals, 15)
+
+		// Statements before the failing one are not rolled back.
+		var exists bool
+		err = conn.QueryRowContext(ctx, "SELECT to_regclass('kept') IS NOT NULL").Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsTrue)
+	})
+
+	c.Run("Foreign connection", func(c *qt.C) {
+		runner := pgdbtemplatepgx.NewMigrationRunner(nil, nil)
+		err := runner.RunMigrations(ctx, nil)
+		c.Assert(err, qt.ErrorMatches, "MigrationRunner requires a .*")
+	})
+}
@@ -0,0 +1,214 @@
+package pgdbtemplatepgx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// migrationFile is a migration file and its contents.
+type migrationFile struct {
+	name    string // Base name, stable across checkouts.
+	path    string // Path used for reading, for error messages.
+	content []byte
+}
+
+// migrationSource reads migration files from a file system.
+type migrationSource struct {
+	readDir  func(name string) ([]fs.DirEntry, error)
+	readFile func(name string) ([]byte, error)
+	join     func(elem ...string) string
+	base     func(name string) string
+}
+
+// osMigrationSource reads migration files from the operating system.
+var osMigrationSource = migrationSource{
+	readDir:  os.ReadDir,
+	readFile: os.ReadFile,
+	join:     filepath.Join,
+	base:     filepath.Base,
+}
+
+// fsMigrationSource reads migration files from fsys.
+func fsMigrationSource(fsys fs.FS) migrationSource {
+	return migrationSource{
+		readDir:  func(name string) ([]fs.DirEntry, error) { return fs.ReadDir(fsys, name) },
+		readFile: func(name string) ([]byte, error) { return fs.ReadFile(fsys, name) },
+		join:     path.Join,
+		base:     path.Base,
+	}
+}
+
+// readMigrationFiles reads the .sql files of every path from the operating
+// system, ordered by orderingFunc within each path.
+func readMigrationFiles(paths []string, orderingFunc func([]string) []string) ([]migrationFile, error) {
+	return osMigrationSource.readFiles(paths, orderingFunc)
+}
+
+// readFiles reads the .sql files of every path, ordered by orderingFunc
+// within each path.
+//
+// A file returned by orderingFunc that does not exist is skipped if a file
+// with the same base name was read from another path, so that an ordering
+// such as a manifest can span several paths. Otherwise reading fails once
+// every path has been read, naming all such files.
+func (s migrationSource) readFiles(paths []string, orderingFunc func([]string) []string) ([]migrationFile, error) {
+	if orderingFunc == nil {
+		orderingFunc = pgdbtemplate.AlphabeticalMigrationFilesSorting
+	}
+
+	var files []migrationFile
+	var missing []migrationFile
+	for _, dir := range paths {
+		entries, err := s.readDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %q: %w", dir, err)
+		}
+
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+				names = append(names, s.join(dir, entry.Name()))
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		for _, name := range orderingFunc(names) {
+			content, err := s.readFile(name) // #nosec G304 -- Migration files are controlled by the application.
+			if errors.Is(err, fs.ErrNotExist) {
+				missing = append(missing, migrationFile{name: s.base(name), path: name})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read migration file %q: %w", name, err)
+			}
+			files = append(files, migrationFile{name: s.base(name), path: name, content: content})
+		}
+	}
+
+	read := make(map[string]bool, len(files))
+	for _, file := range files {
+		read[file.name] = true
+	}
+	var errs []error
+	for _, file := range missing {
+		if !read[file.name] {
+			errs = append(errs, fmt.Errorf("failed to read migration file %q: %w", file.path, fs.ErrNotExist))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return files, nil
+}
+
+// NumericVersionMigrationFilesSorting orders migration files by the numeric
+// version prefix of their base names, e.g. "2_b.sql" before "10_a.sql".
+//
+// Files with equal versions are ordered alphabetically. Files without
+// a numeric prefix are ordered alphabetically after all versioned files.
+func NumericVersionMigrationFilesSorting(files []string) []string {
+	sorted := make([]string, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, vj := migrationVersion(sorted[i]), migrationVersion(sorted[j])
+		switch {
+		case vi == "" && vj != "":
+			return false
+		case vi != "" && vj == "":
+			return true
+		case len(vi) != len(vj):
+			// Versions have no leading zeros, so shorter is smaller.
+			return len(vi) < len(vj)
+		case vi != vj:
+			return vi < vj
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// migrationVersion returns the numeric prefix of the base name of file
+// without leading zeros, "0" for an all-zero prefix, or an empty string
+// if there is no numeric prefix.
+func migrationVersion(file string) string {
+	name := file[strings.LastIndexAny(file, `/\`)+1:]
+	end := 0
+	for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return ""
+	}
+	if version := strings.TrimLeft(name[:end], "0"); version != "" {
+		return version
+	}
+	return "0"
+}
+
+// ManifestMigrationFilesOrdering returns an ordering function that orders
+// migration files as listed in manifest, matched by base name.
+//
+// Files not listed in manifest are not run. Every migration directory is
+// ordered separately, and entries missing from a directory are skipped
+// there if another directory provides them, so that one manifest can span
+// several directories. Reading the migrations fails with the names of the
+// entries that no directory provides.
+func ManifestMigrationFilesOrdering(manifest []string) func([]string) []string {
+	return func(files []string) []string {
+		if len(files) == 0 {
+			return nil
+		}
+		dir := files[0][:strings.LastIndexAny(files[0], `/\`)+1]
+		byName := make(map[string]string, len(files))
+		for _, file := range files {
+			byName[file[len(dir):]] = file
+		}
+
+		ordered := make([]string, 0, len(manifest))
+		for _, name := range manifest {
+			if file, exists := byName[name]; exists {
+				ordered = append(ordered, file)
+			} else {
+				ordered = append(ordered, dir+name)
+			}
+		}
+		return ordered
+	}
+}
+
+// ReadMigrationManifest reads a migration manifest from fsys.
+//
+// A manifest lists one migration file name per line. Blank lines
+// and lines starting with "#" are ignored.
+func ReadMigrationManifest(fsys fs.FS, name string) ([]string, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration manifest %q: %w", name, err)
+	}
+
+	var manifest []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		manifest = append(manifest, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse migration manifest %q: %w", name, err)
+	}
+	return manifest, nil
+}
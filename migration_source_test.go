@@ -0,0 +1,137 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestNumericVersionMigrationFilesSorting(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	sorted := pgdbtemplatepgx.NumericVersionMigrationFilesSorting([]string{
+		"migrations/10_c.sql",
+		"migrations/seed.sql",
+		"migrations/2_b.sql",
+		"migrations/0002_a.sql",
+		"migrations/1_a.sql",
+		"migrations/20240101120000_big.sql",
+	})
+	c.Assert(sorted, qt.DeepEquals, []string{
+		"migrations/1_a.sql",
+		"migrations/0002_a.sql",
+		"migrations/2_b.sql",
+		"migrations/10_c.sql",
+		"migrations/20240101120000_big.sql",
+		"migrations/seed.sql",
+	})
+}
+
+func TestReadMigrationManifest(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	fsys := fstest.MapFS{
+		"manifest.txt": {Data: []byte("# Applied in this order.\n002_b.sql\n\n  001_a.sql\n")},
+	}
+	manifest, err := pgdbtemplatepgx.ReadMigrationManifest(fsys, "manifest.txt")
+	c.Assert(err, qt.IsNil)
+	c.Assert(manifest, qt.DeepEquals, []string{"002_b.sql", "001_a.sql"})
+
+	_, err = pgdbtemplatepgx.ReadMigrationManifest(fsys, "missing.txt")
+	c.Assert(err, qt.ErrorMatches, `failed to read migration manifest "missing.txt": .*`)
+}
+
+func TestFSMigrationRunner(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"migrations/1_create.sql":  {Data: []byte("CREATE TABLE steps (step TEXT);")},
+		"migrations/2_insert.sql":  {Data: []byte("INSERT INTO steps VALUES ('2');")},
+		"migrations/10_insert.sql": {Data: []byte("INSERT INTO steps VALUES ('10');")},
+		"migrations/README.md":     {Data: []byte("Not a migration.")},
+	}
+
+	// steps runs the migrations of fsys and returns the inserted steps.
+	steps := func(c *qt.C, runner pgdbtemplate.MigrationRunner) ([]string, error) {
+		tm := newTestTemplateManager(c, runner)
+		if err := tm.Initialize(ctx); err != nil {
+			return nil, err
+		}
+
+		conn, _, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		var steps []string
+		err = conn.QueryRowContext(ctx,
+			"SELECT COALESCE(array_agg(step ORDER BY ctid), '{}') FROM steps",
+		).Scan(&steps)
+		c.Assert(err, qt.IsNil)
+		return steps, nil
+	}
+
+	c.Run("Numeric version ordering", func(c *qt.C) {
+		c.Parallel()
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"migrations"},
+			pgdbtemplatepgx.NumericVersionMigrationFilesSorting)
+		got, err := steps(c, runner)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"2", "10"})
+	})
+
+	c.Run("Alphabetical ordering by default", func(c *qt.C) {
+		c.Parallel()
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"migrations"}, nil)
+		got, err := steps(c, runner)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"10", "2"})
+	})
+
+	c.Run("Manifest ordering", func(c *qt.C) {
+		c.Parallel()
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"migrations"},
+			pgdbtemplatepgx.ManifestMigrationFilesOrdering([]string{"1_create.sql", "10_insert.sql"}))
+		got, err := steps(c, runner)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"10"})
+	})
+
+	c.Run("Manifest listing a missing file", func(c *qt.C) {
+		c.Parallel()
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"migrations"},
+			pgdbtemplatepgx.ManifestMigrationFilesOrdering([]string{"1_create.sql", "3_missing.sql"}))
+		_, err := steps(c, runner)
+		c.Assert(err, qt.ErrorMatches, `.*failed to read migration file "migrations/3_missing.sql".*`)
+	})
+
+	c.Run("Manifest spanning several directories", func(c *qt.C) {
+		c.Parallel()
+		fsys := fstest.MapFS{
+			"schema/1_create.sql":   {Data: []byte("CREATE TABLE steps (step TEXT);")},
+			"schema/9_unlisted.sql": {Data: []byte("INSERT INTO steps VALUES ('9');")},
+			"data/3_insert.sql":     {Data: []byte("INSERT INTO steps VALUES ('3');")},
+			"data/2_insert.sql":     {Data: []byte("INSERT INTO steps VALUES ('2');")},
+		}
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"schema", "data"},
+			pgdbtemplatepgx.ManifestMigrationFilesOrdering([]string{"1_create.sql", "3_insert.sql", "2_insert.sql"}))
+		got, err := steps(c, runner)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"3", "2"})
+
+		// Entries found in no directory are reported
+		// once all directories have been read.
+		runner = pgdbtemplatepgx.NewFSMigrationRunner(fsys, []string{"schema", "data"},
+			pgdbtemplatepgx.ManifestMigrationFilesOrdering([]string{"1_create.sql", "4_missing.sql", "2_insert.sql", "5_missing.sql"}))
+		_, err = steps(c, runner)
+		c.Assert(err, qt.ErrorMatches, `(?s).*"schema/4_missing.sql".*"schema/5_missing.sql".*"data/4_missing.sql".*"data/5_missing.sql".*`)
+	})
+}
@@ -43,3 +43,13 @@ func WithMaxConnIdleTime(d time.Duration) ConnectionOption {
 		p.poolConfig.MaxConnIdleTime = d
 	}
 }
+
+// WithAdminDatabase sets the administrative database used for
+// CREATE DATABASE and DROP DATABASE operations issued by the provider.
+//
+// If not set, "postgres" will be used.
+func WithAdminDatabase(dbName string) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.adminDBName = dbName
+	}
+}
@@ -0,0 +1,29 @@
+package pgdbtemplatepgxtest
+
+import (
+	"strings"
+	"testing"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// AssertPlan fails t, printing the plan, if any of checks fails for it:
+//
+//	plan, err := pgdbtemplatepgx.Explain(ctx, conn, "SELECT * FROM users WHERE email = $1", []any{"a@example.com"})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	pgdbtemplatepgxtest.AssertPlan(t, plan, pgdbtemplatepgx.UsesIndex("users_email_idx"))
+func AssertPlan(t testing.TB, plan *pgdbtemplatepgx.QueryPlan, checks ...pgdbtemplatepgx.PlanCheck) {
+	t.Helper()
+
+	var failures []string
+	for _, check := range checks {
+		if err := check(plan); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		t.Errorf("query plan checks failed:\n\t%s\nplan:\n%s", strings.Join(failures, "\n\t"), plan)
+	}
+}
@@ -0,0 +1,31 @@
+// Package pgdbtemplatepgxtest provides test assertions for databases
+// created through pgdbtemplatepgx, keeping the testing package out of
+// the import graph of code that only creates databases.
+//
+// Importing the package registers the -update flag with the flag package.
+// Running the tests of a package importing it with the flag makes golden
+// assertions rewrite their golden files instead of comparing against them:
+//
+//	go test ./internal/store -run TestSchema -update
+//
+// Test packages importing pgdbtemplatepgxtest must therefore not define
+// an -update flag of their own.
+package pgdbtemplatepgxtest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// update is the -update flag.
+var update = flag.Bool("update", false, "rewrite the golden files of pgdbtemplatepgxtest assertions")
+
+// writeGoldenFile writes data to the golden file at path,
+// creating its directory if needed.
+func writeGoldenFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644) // #nosec G306 -- Golden files are checked in.
+}
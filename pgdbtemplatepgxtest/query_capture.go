@@ -0,0 +1,60 @@
+package pgdbtemplatepgxtest
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// AssertQueryCount fails t, listing the captured queries, unless exactly
+// want queries were captured for the database behind conn.
+func AssertQueryCount(t testing.TB, conn pgdbtemplate.DatabaseConnection, want int) {
+	t.Helper()
+
+	queries := capturedQueries(t, conn)
+	if len(queries) != want {
+		t.Errorf("expected %d queries, got %d\n%s", want, len(queries), pgdbtemplatepgx.FormatCapturedQueries(queries))
+	}
+}
+
+// AssertNoQueryMatching fails t, listing the captured queries, if the SQL
+// of any query captured for the database behind conn matches the regular
+// expression pattern, e.g. to catch N+1 queries:
+//
+//	pgdbtemplatepgxtest.AssertNoQueryMatching(t, conn, `FROM comments WHERE post_id = \$1`)
+func AssertNoQueryMatching(t testing.TB, conn pgdbtemplate.DatabaseConnection, pattern string) {
+	t.Helper()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("invalid query pattern: %v", err)
+	}
+	var matches []int
+	queries := capturedQueries(t, conn)
+	for i, q := range queries {
+		if re.MatchString(q.SQL) {
+			matches = append(matches, i+1)
+		}
+	}
+	if len(matches) > 0 {
+		t.Errorf("expected no queries matching %q, got %d (numbers %v)\n%s",
+			pattern, len(matches), matches, pgdbtemplatepgx.FormatCapturedQueries(queries))
+	}
+}
+
+// capturedQueries returns the queries captured for the database behind
+// conn, failing t if query capture is not enabled for it.
+func capturedQueries(t testing.TB, conn pgdbtemplate.DatabaseConnection) []pgdbtemplatepgx.CapturedQuery {
+	t.Helper()
+
+	pgxConn, ok := conn.(*pgdbtemplatepgx.DatabaseConnection)
+	if !ok {
+		t.Fatalf("query capture requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+	if !pgxConn.CapturesQueries() {
+		t.Fatalf("query capture is not enabled, create the provider with WithQueryCapture")
+	}
+	return pgxConn.CapturedQueries()
+}
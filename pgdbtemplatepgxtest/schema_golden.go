@@ -0,0 +1,64 @@
+package pgdbtemplatepgxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// AssertSchemaGolden compares the schema of the database behind conn with
+// the snapshot stored in the golden file at path, failing t with the
+// differences if they do not match.
+//
+// Running the tests with the -update flag writes the current snapshot to
+// path instead, so that schema changes show up in code review as changes
+// to the golden file.
+func AssertSchemaGolden(t testing.TB, conn pgdbtemplate.DatabaseConnection, path string) {
+	t.Helper()
+
+	snapshot, err := pgdbtemplatepgx.SnapshotSchema(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("failed to snapshot schema: %v", err)
+	}
+
+	if *update {
+		data, err := snapshot.Marshal()
+		if err != nil {
+			t.Fatalf("failed to encode schema snapshot: %v", err)
+		}
+		if err := writeGoldenFile(path, data); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		t.Logf("updated golden file %s", path)
+		return
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- Golden files are controlled by the test.
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist; run the test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	golden, err := pgdbtemplatepgx.ParseSchemaSnapshot(data)
+	if err != nil {
+		t.Fatalf("failed to parse golden file %s: %v", path, err)
+	}
+
+	changes := pgdbtemplatepgx.DiffSchemas(golden, snapshot)
+	if len(changes) == 0 {
+		return
+	}
+	var diff strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&diff, "\n\t%s", change)
+	}
+	t.Errorf("schema does not match golden file %s (run the test with -update to accept):%s", path, diff.String())
+}
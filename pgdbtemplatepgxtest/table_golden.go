@@ -0,0 +1,95 @@
+package pgdbtemplatepgxtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// AssertTablesGolden compares the contents of tables with the golden file
+// at path, failing t with a line diff if they do not match.
+//
+// Golden files ending in ".json" hold JSON, others YAML. As with
+// AssertSchemaGolden, running the tests with the -update flag writes
+// the current contents to path instead.
+func AssertTablesGolden(t testing.TB, conn pgdbtemplate.DatabaseConnection, path string, tables []string, opts ...pgdbtemplatepgx.TableSnapshotOption) {
+	t.Helper()
+
+	if strings.HasSuffix(path, ".json") {
+		opts = append(opts, pgdbtemplatepgx.WithJSONSnapshot())
+	}
+	got, err := pgdbtemplatepgx.SnapshotTables(context.Background(), conn, tables, opts...)
+	if err != nil {
+		t.Fatalf("failed to snapshot tables: %v", err)
+	}
+
+	if *update {
+		if err := writeGoldenFile(path, got); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		t.Logf("updated golden file %s", path)
+		return
+	}
+
+	want, err := os.ReadFile(path) // #nosec G304 -- Golden files are controlled by the test.
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist; run the test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("tables do not match golden file %s (run the test with -update to accept):\n%s",
+			path, lineDiff(string(want), string(got)))
+	}
+}
+
+// lineDiff describes the differences between the lines of want and got,
+// prefixing removed lines with "-" and added lines with "+".
+func lineDiff(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&diff, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&diff, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return diff.String()
+}
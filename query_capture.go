@@ -0,0 +1,198 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CapturedQuery is a statement executed on a database
+// while query capture was enabled.
+type CapturedQuery struct {
+	SQL      string
+	Args     []any
+	Duration time.Duration
+	Err      error
+}
+
+// String returns the statement on a single line with its arguments,
+// duration and error, if any.
+func (q CapturedQuery) String() string {
+	var b strings.Builder
+	b.WriteString(singleLine(q.SQL))
+	if len(q.Args) > 0 {
+		fmt.Fprintf(&b, " %v", q.Args)
+	}
+	fmt.Fprintf(&b, " (%s)", q.Duration.Round(time.Microsecond))
+	if q.Err != nil {
+		fmt.Fprintf(&b, " error: %v", q.Err)
+	}
+	return b.String()
+}
+
+// FormatCapturedQueries returns a numbered listing of queries, one per
+// line, as printed by the query assertions on failure.
+func FormatCapturedQueries(queries []CapturedQuery) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d captured queries:", len(queries))
+	for i, q := range queries {
+		fmt.Fprintf(&b, "\n\t%d. %s", i+1, q)
+	}
+	return b.String()
+}
+
+// WithQueryCapture makes the provider record every statement executed
+// through its pools, keyed by database name, so that tests can assert on
+// the queries issued by the code under test:
+//
+//	conn.ResetCapturedQueries()
+//	handler.ServeHTTP(rec, req)
+//	pgdbtemplatepgxtest.AssertQueryCount(t, conn, 3)
+//
+// Statements are captured by a pgx.QueryTracer installed on each pool.
+// Statements sent as batches or with CopyFrom are not captured. The
+// queries of a database are dropped when its last pool is closed.
+func WithQueryCapture() ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.queryCapture = true
+	}
+}
+
+// queryLog holds the queries captured for one database.
+type queryLog struct {
+	mu      sync.Mutex
+	queries []CapturedQuery
+}
+
+func (l *queryLog) add(q CapturedQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queries = append(l.queries, q)
+}
+
+// queryLog returns the query log of dbName, creating it on first use.
+func (p *ConnectionProvider) queryLog(dbName string) *queryLog {
+	p.queryLogsMu.Lock()
+	defer p.queryLogsMu.Unlock()
+
+	log, ok := p.queryLogs[dbName]
+	if !ok {
+		log = &queryLog{}
+		p.queryLogs[dbName] = log
+	}
+	return log
+}
+
+// forgetQueryLog drops the query log of dbName.
+func (p *ConnectionProvider) forgetQueryLog(dbName string) {
+	p.queryLogsMu.Lock()
+	defer p.queryLogsMu.Unlock()
+	delete(p.queryLogs, dbName)
+}
+
+// capturePoolQueries installs a tracer recording queries into
+// the query log of dbName.
+func (p *ConnectionProvider) capturePoolQueries(config *pgxpool.Config, dbName string) {
+	if !p.queryCapture {
+		return
+	}
+	config.ConnConfig.Tracer = &queryTracer{
+		log:  p.queryLog(dbName),
+		next: config.ConnConfig.Tracer,
+	}
+}
+
+// CapturesQueries reports whether queries are captured for the database
+// of the connection, i.e. whether the provider was created with
+// WithQueryCapture.
+func (c *DatabaseConnection) CapturesQueries() bool {
+	return c.provider != nil && c.provider.queryCapture
+}
+
+// CapturedQueries returns the queries captured for the database of the
+// connection, in order of completion. It returns nil unless the provider
+// was created with WithQueryCapture.
+//
+// Queries are captured per database, so they include those executed
+// through other connections to the same database.
+func (c *DatabaseConnection) CapturedQueries() []CapturedQuery {
+	if c.provider == nil {
+		return nil
+	}
+	c.provider.queryLogsMu.Lock()
+	log, ok := c.provider.queryLogs[c.dbName]
+	c.provider.queryLogsMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return append([]CapturedQuery(nil), log.queries...)
+}
+
+// ResetCapturedQueries discards the queries captured so far for the
+// database of the connection, e.g. those issued while setting up a test.
+func (c *DatabaseConnection) ResetCapturedQueries() {
+	if c.provider == nil {
+		return
+	}
+	c.provider.queryLogsMu.Lock()
+	log, ok := c.provider.queryLogs[c.dbName]
+	c.provider.queryLogsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.queries = nil
+}
+
+// queryTracer records queries into a query log.
+type queryTracer struct {
+	log  *queryLog
+	next pgx.QueryTracer
+}
+
+// queryTraceKey is the context key of the query being traced.
+type queryTraceKey struct{}
+
+// queryTrace is a query being traced.
+type queryTrace struct {
+	sql   string
+	args  []any
+	start time.Time
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (t *queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if t.next != nil {
+		ctx = t.next.TraceQueryStart(ctx, conn, data)
+	}
+	return context.WithValue(ctx, queryTraceKey{}, &queryTrace{
+		sql:   data.SQL,
+		args:  data.Args,
+		start: time.Now(),
+	})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if trace, ok := ctx.Value(queryTraceKey{}).(*queryTrace); ok {
+		t.log.add(CapturedQuery{
+			SQL:      trace.sql,
+			Args:     trace.args,
+			Duration: time.Since(trace.start),
+			Err:      data.Err,
+		})
+	}
+	if t.next != nil {
+		t.next.TraceQueryEnd(ctx, conn, data)
+	}
+}
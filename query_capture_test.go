@@ -0,0 +1,93 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+	"github.com/andrei-polukhin/pgdbtemplate-pgx/pgdbtemplatepgxtest"
+)
+
+func TestQueryCapture(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Capture queries per database", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithQueryCapture())
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		pgxConn := conn.(*pgdbtemplatepgx.DatabaseConnection)
+
+		// Queries on another database are captured separately.
+		other, otherName := createTestDatabase(c, provider)
+		defer func() { c.Assert(other.Close(), qt.IsNil) }()
+		c.Assert(otherName, qt.Not(qt.Equals), pgxConn.DatabaseName())
+
+		pgxConn.ResetCapturedQueries()
+		_, err := conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ($1)", "a")
+		c.Assert(err, qt.IsNil)
+		var name string
+		err = conn.QueryRowContext(ctx, "SELECT name FROM test_table WHERE name = $1", "a").Scan(&name)
+		c.Assert(err, qt.IsNil)
+		_, err = conn.ExecContext(ctx, "SELECT * FROM missing")
+		c.Assert(err, qt.Not(qt.IsNil))
+		_, err = other.ExecContext(ctx, "SELECT 1")
+		c.Assert(err, qt.IsNil)
+
+		queries := pgxConn.CapturedQueries()
+		c.Assert(queries, qt.HasLen, 3)
+		c.Assert(queries[0].SQL, qt.Equals, "INSERT INTO test_table (name) VALUES ($1)")
+		c.Assert(queries[0].Args, qt.DeepEquals, []any{"a"})
+		c.Assert(queries[0].Err, qt.IsNil)
+		c.Assert(queries[1].SQL, qt.Equals, "SELECT name FROM test_table WHERE name = $1")
+		c.Assert(queries[2].Err, qt.ErrorMatches, `.*relation "missing" does not exist.*`)
+
+		failures := recordFailures(t, func(tb testing.TB) {
+			pgdbtemplatepgxtest.AssertQueryCount(tb, conn, 3)
+			pgdbtemplatepgxtest.AssertNoQueryMatching(tb, conn, `DELETE`)
+		})
+		c.Assert(failures, qt.HasLen, 0)
+
+		failures = recordFailures(t, func(tb testing.TB) { pgdbtemplatepgxtest.AssertQueryCount(tb, conn, 2) })
+		c.Assert(failures, qt.HasLen, 1)
+		c.Assert(failures[0], qt.Matches, `expected 2 queries, got 3
+3 captured queries:
+	1\. INSERT INTO test_table \(name\) VALUES \(\$1\) \[a\] \(.*\)
+	2\. SELECT name FROM test_table WHERE name = \$1 \[a\] \(.*\)
+	3\. SELECT \* FROM missing \(.*\) error: .*`)
+
+		failures = recordFailures(t, func(tb testing.TB) {
+			pgdbtemplatepgxtest.AssertNoQueryMatching(tb, conn, `FROM test_table WHERE`)
+		})
+		c.Assert(failures, qt.HasLen, 1)
+		c.Assert(failures[0], qt.Matches, `expected no queries matching "FROM test_table WHERE", got 1 \(numbers \[2\]\)\n3 captured queries:(.|\n)*`)
+
+		pgxConn.ResetCapturedQueries()
+		c.Assert(pgxConn.CapturedQueries(), qt.HasLen, 0)
+	})
+
+	c.Run("Capture disabled", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := conn.ExecContext(ctx, "SELECT 1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.(*pgdbtemplatepgx.DatabaseConnection).CapturedQueries(), qt.IsNil)
+
+		failures := recordFailures(t, func(tb testing.TB) { pgdbtemplatepgxtest.AssertQueryCount(tb, conn, 0) })
+		c.Assert(failures, qt.DeepEquals, []string{
+			"query capture is not enabled, create the provider with WithQueryCapture",
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WithReplicaLag delays every query sent through connections returned by
+// ConnectReadOnly by lag, emulating the delayed visibility of writes on an
+// asynchronous replica. Reads are not served from an older snapshot, so
+// the delay surfaces code that relies on timing between writes and reads
+// rather than code that reads stale data.
+//
+// The delay is not included in the durations of captured queries
+// and slow query reports.
+func WithReplicaLag(lag time.Duration) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.replicaLag = lag
+	}
+}
+
+// ConnectReadOnly returns a connection to the specified database through
+// a separate pool emulating a read replica of it: its sessions run with
+// default_transaction_read_only enabled, so that writes routed to it fail,
+// and with the delay set by WithReplicaLag before every query.
+//
+// Replica pools are shared between ConnectReadOnly calls for the same
+// database, are independent of the pool returned by Connect and are
+// reconnected like it by Checkpoint and Restore. Sessions may still opt
+// into writing, e.g. with BEGIN READ WRITE.
+func (p *ConnectionProvider) ConnectReadOnly(ctx context.Context, databaseName string) (*DatabaseConnection, error) {
+	return p.connect(ctx, poolKey{database: databaseName, readOnly: true})
+}
+
+// emulateReplica makes the sessions of a pool read-only
+// and delays their queries by the replica lag.
+func (p *ConnectionProvider) emulateReplica(config *pgxpool.Config) {
+	config.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	if p.replicaLag > 0 {
+		config.ConnConfig.Tracer = &replicaLagTracer{
+			lag:  p.replicaLag,
+			next: config.ConnConfig.Tracer,
+		}
+	}
+}
+
+// replicaLagTracer delays queries before they are sent.
+type replicaLagTracer struct {
+	lag  time.Duration
+	next pgx.QueryTracer
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+//
+// It sleeps for the lag, or until ctx is done, in which case
+// the query fails with the context error.
+func (t *replicaLagTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	timer := time.NewTimer(t.lag)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	if t.next != nil {
+		ctx = t.next.TraceQueryStart(ctx, conn, data)
+	}
+	return ctx
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *replicaLagTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if t.next != nil {
+		t.next.TraceQueryEnd(ctx, conn, data)
+	}
+}
@@ -0,0 +1,107 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestConnectReadOnly(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Replica pool rejects writes", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		replica, err := provider.ConnectReadOnly(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(replica.DatabaseName(), qt.Equals, dbName)
+		c.Assert(replica.Pool, qt.Not(qt.Equals), conn.(*pgdbtemplatepgx.DatabaseConnection).Pool)
+
+		_, err = replica.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('replica')")
+		var pgErr *pgconn.PgError
+		c.Assert(errors.As(err, &pgErr), qt.IsTrue)
+		c.Assert(pgErr.Code, qt.Equals, "25006") // read_only_sql_transaction
+
+		// Writes through the primary are visible on the replica.
+		_, err = conn.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ('primary')")
+		c.Assert(err, qt.IsNil)
+		var count int
+		c.Assert(replica.QueryRowContext(ctx, "SELECT count(*) FROM test_table").Scan(&count), qt.IsNil)
+		c.Assert(count, qt.Equals, 3)
+
+		// Closing the replica leaves the primary pool alone.
+		c.Assert(replica.Close(), qt.IsNil)
+		c.Assert(conn.QueryRowContext(ctx, "SELECT count(*) FROM test_table").Scan(&count), qt.IsNil)
+	})
+
+	c.Run("Replica lag", func(c *qt.C) {
+		c.Parallel()
+		const lag = 200 * time.Millisecond
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithReplicaLag(lag),
+			pgdbtemplatepgx.WithQueryCapture(),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		replica, err := provider.ConnectReadOnly(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(replica.Close(), qt.IsNil) }()
+		replica.ResetCapturedQueries()
+
+		start := time.Now()
+		var one int
+		c.Assert(replica.QueryRowContext(ctx, "SELECT 1").Scan(&one), qt.IsNil)
+		c.Assert(time.Since(start) >= lag, qt.IsTrue)
+
+		// The lag is not part of the captured duration.
+		queries := replica.CapturedQueries()
+		c.Assert(queries, qt.HasLen, 1)
+		c.Assert(queries[0].Duration < lag, qt.IsTrue)
+
+		// Waiting for the lag honors the context.
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err = replica.QueryRowContext(timeoutCtx, "SELECT 1").Scan(&one)
+		c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+	})
+
+	c.Run("Replica survives restore", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, dbName := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		replica, err := provider.ConnectReadOnly(ctx, dbName)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(replica.Close(), qt.IsNil) }()
+
+		id, err := provider.Checkpoint(ctx, conn)
+		c.Assert(err, qt.IsNil)
+		_, err = conn.ExecContext(ctx, "DELETE FROM test_table")
+		c.Assert(err, qt.IsNil)
+		c.Assert(provider.Restore(ctx, id), qt.IsNil)
+
+		var count int
+		c.Assert(replica.QueryRowContext(ctx, "SELECT count(*) FROM test_table").Scan(&count), qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+		_, err = replica.ExecContext(ctx, "DELETE FROM test_table")
+		c.Assert(err, qt.ErrorMatches, ".*read-only transaction.*")
+	})
+}
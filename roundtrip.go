@@ -0,0 +1,179 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// roundTripBaseDatabase is cloned to get an empty database
+// for VerifyRoundTrip, like CREATE DATABASE does by default.
+const roundTripBaseDatabase = "template1"
+
+// RoundTripError reports a migration that does not survive
+// a down and up round trip.
+type RoundTripError struct {
+	// Version is the version of the failing migration.
+	Version string
+	// Name is the file name of the failing migration.
+	Name string
+	// Step is the failing step: "up", "down" or "redo".
+	Step string
+	// Err describes the failure.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *RoundTripError) Error() string {
+	return fmt.Sprintf("migration %s (%s) fails round trip at %s: %v", e.Version, e.Name, e.Step, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RoundTripError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyRoundTrip checks that every migration can be reverted by its down
+// migration and re-applied with the same result.
+//
+// The migrations are run on a scratch database cloned by provider, which
+// is dropped afterwards. After applying all up migrations, the migrations
+// are walked back from the newest one: each down migration must restore the
+// schema seen before its up migration, and re-applying the up migration
+// must produce the schema seen after it. Schemas are compared by
+// SnapshotSchema and mismatches are described by DiffSchemas.
+//
+// Every migration needs a down migration, so the runner must use a
+// MigrationFormat other than NativeMigrationFormat. The first failing
+// migration is reported as *RoundTripError.
+func (r *MigrationRunner) VerifyRoundTrip(ctx context.Context, provider *ConnectionProvider) (err error) {
+	migrations, err := r.migrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.down == nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "down", Err: errors.New("no down migration")}
+		}
+	}
+
+	scratch, err := provider.Fork(ctx, roundTripBaseDatabase)
+	if err != nil {
+		return fmt.Errorf("failed to create scratch database: %w", err)
+	}
+	defer func() {
+		if closeErr := scratch.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		if releaseErr := provider.ReleaseFork(ctx, scratch.DatabaseName()); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+	}()
+
+	poolConn, err := scratch.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer poolConn.Release()
+
+	// Like RunMigrations, the round trip is not bound
+	// by the session timeouts meant for tests.
+	restoreTimeouts, err := liftSessionTimeouts(ctx, poolConn)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, restoreTimeouts()) }()
+
+	// Schemas are snapshot through the acquired connection as well,
+	// so that the round trip does not need a second one.
+	conn := acquiredConnection{poolConn}
+
+	// schemas[i] is the schema with the first i migrations applied.
+	schemas := make([]*SchemaSnapshot, 0, len(migrations)+1)
+	schema, err := SnapshotSchema(ctx, conn)
+	if err != nil {
+		return err
+	}
+	schemas = append(schemas, schema)
+
+	for _, m := range migrations {
+		if err := runMigrationScript(ctx, poolConn, m.up); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "up", Err: err}
+		}
+		schema, err := SnapshotSchema(ctx, conn)
+		if err != nil {
+			return err
+		}
+		schemas = append(schemas, schema)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if err := runMigrationScript(ctx, poolConn, *m.down); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "down", Err: err}
+		}
+		if err := compareSchema(ctx, conn, schemas[i]); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "down",
+				Err: fmt.Errorf("schema differs from the schema before the migration: %w", err)}
+		}
+
+		if err := runMigrationScript(ctx, poolConn, m.up); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "redo", Err: err}
+		}
+		if err := compareSchema(ctx, conn, schemas[i+1]); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "redo",
+				Err: fmt.Errorf("schema differs from the schema after the first run: %w", err)}
+		}
+
+		if err := runMigrationScript(ctx, poolConn, *m.down); err != nil {
+			return &RoundTripError{Version: m.version, Name: m.name, Step: "redo", Err: err}
+		}
+	}
+	return nil
+}
+
+// compareSchema compares the schema of conn's database with want,
+// describing the differences on a single line.
+func compareSchema(ctx context.Context, conn pgdbtemplate.DatabaseConnection, want *SchemaSnapshot) error {
+	got, err := SnapshotSchema(ctx, conn)
+	if err != nil {
+		return err
+	}
+	changes := DiffSchemas(want, got)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, len(changes))
+	for i, change := range changes {
+		descriptions[i] = change.String()
+	}
+	return errors.New(strings.Join(descriptions, "; "))
+}
+
+// acquiredConnection implements pgdbtemplate.DatabaseConnection
+// on a connection acquired from a pool.
+type acquiredConnection struct {
+	*pgxpool.Conn
+}
+
+// ExecContext implements pgdbtemplate.DatabaseConnection.ExecContext.
+func (c acquiredConnection) ExecContext(ctx context.Context, query string, args ...any) (any, error) {
+	return c.Exec(ctx, query, args...)
+}
+
+// QueryRowContext implements pgdbtemplate.DatabaseConnection.QueryRowContext.
+func (c acquiredConnection) QueryRowContext(ctx context.Context, query string, args ...any) pgdbtemplate.Row {
+	return c.QueryRow(ctx, query, args...)
+}
+
+// Close implements pgdbtemplate.DatabaseConnection.Close.
+//
+// It does nothing: the connection is released by whoever acquired it.
+func (acquiredConnection) Close() error {
+	return nil
+}
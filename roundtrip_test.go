@@ -0,0 +1,98 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// verify runs VerifyRoundTrip for golang-migrate files.
+	verify := func(c *qt.C, files fstest.MapFS, opts ...pgdbtemplatepgx.ConnectionOption) error {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx, opts...)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		runner := pgdbtemplatepgx.NewFSMigrationRunner(files, []string{"."}, nil,
+			pgdbtemplatepgx.WithMigrationFormat(pgdbtemplatepgx.GolangMigrateFormat))
+		return runner.VerifyRoundTrip(ctx, provider)
+	}
+
+	c.Run("Reversible migrations", func(c *qt.C) {
+		c.Parallel()
+		err := verify(c, fstest.MapFS{
+			"1_users.up.sql":     {Data: []byte("CREATE TABLE users (id SERIAL PRIMARY KEY, email TEXT);")},
+			"1_users.down.sql":   {Data: []byte("DROP TABLE users;")},
+			"2_index.up.sql":     {Data: []byte("CREATE UNIQUE INDEX users_email_idx ON users (email);")},
+			"2_index.down.sql":   {Data: []byte("DROP INDEX users_email_idx;")},
+			"3_status.up.sql":    {Data: []byte("CREATE TYPE status AS ENUM ('active'); ALTER TABLE users ADD COLUMN status status;")},
+			"3_status.down.sql":  {Data: []byte("ALTER TABLE users DROP COLUMN status; DROP TYPE status;")},
+			"10_orders.up.sql":   {Data: []byte("CREATE TABLE orders (user_id INT REFERENCES users (id));")},
+			"10_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+		})
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Single connection with session timeouts", func(c *qt.C) {
+		c.Parallel()
+		err := verify(c, fstest.MapFS{
+			"1_slow.up.sql":   {Data: []byte("SELECT pg_sleep(0.2); CREATE TABLE slow (id INT);")},
+			"1_slow.down.sql": {Data: []byte("DROP TABLE slow;")},
+		},
+			pgdbtemplatepgx.WithMaxConns(1),
+			pgdbtemplatepgx.WithStatementTimeout(50*time.Millisecond),
+		)
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Asymmetric down migration", func(c *qt.C) {
+		c.Parallel()
+		err := verify(c, fstest.MapFS{
+			"1_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+			"1_users.down.sql": {Data: []byte("DROP TABLE users;")},
+			// The down migration forgets the default.
+			"2_default.up.sql":   {Data: []byte("ALTER TABLE users ADD COLUMN name TEXT; ALTER TABLE users ALTER COLUMN id SET DEFAULT 0;")},
+			"2_default.down.sql": {Data: []byte("ALTER TABLE users DROP COLUMN name;")},
+			"3_noop.up.sql":      {Data: []byte("SELECT 1;")},
+			"3_noop.down.sql":    {Data: []byte("SELECT 1;")},
+		})
+
+		var roundTripErr *pgdbtemplatepgx.RoundTripError
+		c.Assert(errors.As(err, &roundTripErr), qt.IsTrue)
+		c.Assert(roundTripErr.Version, qt.Equals, "2")
+		c.Assert(roundTripErr.Name, qt.Equals, "2_default.up.sql")
+		c.Assert(roundTripErr.Step, qt.Equals, "down")
+		c.Assert(err, qt.ErrorMatches, `migration 2 \(2_default.up.sql\) fails round trip at down: schema differs .*: `+
+			`~ column public.users.id: integer \(position 1\) -> integer DEFAULT 0 \(position 1\)`)
+	})
+
+	c.Run("Down migration leaving objects behind", func(c *qt.C) {
+		c.Parallel()
+		err := verify(c, fstest.MapFS{
+			"1_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT); CREATE SEQUENCE user_ids;")},
+			"1_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		})
+
+		var roundTripErr *pgdbtemplatepgx.RoundTripError
+		c.Assert(errors.As(err, &roundTripErr), qt.IsTrue)
+		c.Assert(roundTripErr.Version, qt.Equals, "1")
+		c.Assert(roundTripErr.Step, qt.Equals, "down")
+	})
+
+	c.Run("Missing down migration", func(c *qt.C) {
+		c.Parallel()
+		err := verify(c, fstest.MapFS{
+			"1_users.up.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+		})
+		c.Assert(err, qt.ErrorMatches, `migration 1 \(1_users.up.sql\) fails round trip at down: no down migration`)
+	})
+}
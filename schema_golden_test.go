@@ -0,0 +1,113 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+	"github.com/andrei-polukhin/pgdbtemplate-pgx/pgdbtemplatepgxtest"
+)
+
+// recordingTB records failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	mu       sync.Mutex
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Logf(format string, args ...any) {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// recordFailures runs assert with a recordingTB and returns the failures.
+func recordFailures(t testing.TB, assert func(tb testing.TB)) []string {
+	tb := &recordingTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert(tb)
+	}()
+	<-done
+	return tb.failures
+}
+
+// assertSchemaGolden runs AssertSchemaGolden and returns the failures.
+func assertSchemaGolden(t testing.TB, conn pgdbtemplate.DatabaseConnection, path string) []string {
+	return recordFailures(t, func(tb testing.TB) {
+		pgdbtemplatepgxtest.AssertSchemaGolden(tb, conn, path)
+	})
+}
+
+// updateGolden runs fn with the -update flag
+// registered by pgdbtemplatepgxtest set.
+func updateGolden(c *qt.C, fn func()) {
+	c.Assert(flag.Set("update", "true"), qt.IsNil)
+	defer func() { c.Assert(flag.Set("update", "false"), qt.IsNil) }()
+	fn()
+}
+
+// Not parallel, since it sets whether golden files are updated.
+func TestAssertSchemaGolden(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	path := filepath.Join(c.TempDir(), "testdata", "schema.golden")
+
+	failures := assertSchemaGolden(t, conn, path)
+	c.Assert(failures, qt.DeepEquals, []string{
+		fmt.Sprintf("golden file %s does not exist; run the test with -update to create it", path),
+	})
+
+	updateGolden(c, func() {
+		c.Assert(assertSchemaGolden(t, conn, path), qt.HasLen, 0)
+	})
+
+	data, err := os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Contains, `"name": "test_table"`)
+
+	// The unchanged schema matches.
+	c.Assert(assertSchemaGolden(t, conn, path), qt.HasLen, 0)
+
+	// A schema change is reported with a readable diff.
+	_, err = conn.ExecContext(ctx, "ALTER TABLE test_table ADD COLUMN email TEXT")
+	c.Assert(err, qt.IsNil)
+	failures = assertSchemaGolden(t, conn, path)
+	c.Assert(failures, qt.DeepEquals, []string{
+		fmt.Sprintf("schema does not match golden file %s (run the test with -update to accept):\n"+
+			"\t+ column public.test_table.email: text (position 4)", path),
+	})
+	// Updating accepts it.
+	updateGolden(c, func() {
+		c.Assert(assertSchemaGolden(t, conn, path), qt.HasLen, 0)
+	})
+	data, err = os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Contains, `"name": "email"`)
+}
@@ -0,0 +1,192 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultSchemaDatabaseName is the default database hosting test schemas.
+const defaultSchemaDatabaseName = "postgres"
+
+// globalTestSchemaCounter is a global atomic counter for unique test schema
+// names across all schema managers.
+var globalTestSchemaCounter int64
+
+// SchemaConfig holds configuration for the schema manager.
+type SchemaConfig struct {
+	// ConnectionProvider provides connections pinned to test schemas.
+	//
+	// This field is required.
+	ConnectionProvider *ConnectionProvider
+	// MigrationRunner runs migrations on every test schema.
+	//
+	// Migrations are executed with search_path pinned to the test schema,
+	// so unqualified object names are created inside it.
+	//
+	// This field is required.
+	MigrationRunner pgdbtemplate.MigrationRunner
+	// DatabaseName is the name of the database hosting the test schemas.
+	//
+	// If empty, "postgres" will be used.
+	DatabaseName string
+	// TestSchemaPrefix is the prefix for test schema names.
+	//
+	// If empty, "test_" will be used.
+	TestSchemaPrefix string
+}
+
+// SchemaManager isolates tests by giving each of them a fresh schema
+// inside a single database instead of a fresh database.
+//
+// It is an alternative to pgdbtemplate.TemplateManager for servers that
+// limit the number of databases or forbid CREATE DATABASE. Every test
+// schema is built by replaying the migrations with search_path pinned
+// to it, and connections returned for it keep that search_path.
+//
+// Extensions are database-wide: install them once in a schema that the
+// migrations qualify explicitly rather than from the replayed migrations.
+type SchemaManager struct {
+	provider *ConnectionProvider
+	migrator pgdbtemplate.MigrationRunner
+
+	databaseName string
+	testPrefix   string
+
+	mu             sync.Mutex
+	createdSchemas sync.Map // Tracks created test schemas for cleanup.
+}
+
+// NewSchemaManager creates a new schema manager.
+func NewSchemaManager(config SchemaConfig) (*SchemaManager, error) {
+	// Validate required fields.
+	if config.ConnectionProvider == nil {
+		return nil, fmt.Errorf("ConnectionProvider is required")
+	}
+	if config.MigrationRunner == nil {
+		return nil, fmt.Errorf("MigrationRunner is required")
+	}
+
+	databaseName := config.DatabaseName
+	if databaseName == "" {
+		databaseName = defaultSchemaDatabaseName
+	}
+
+	testPrefix := config.TestSchemaPrefix
+	if testPrefix == "" {
+		testPrefix = "test_"
+	}
+
+	return &SchemaManager{
+		provider:     config.ConnectionProvider,
+		migrator:     config.MigrationRunner,
+		databaseName: databaseName,
+		testPrefix:   testPrefix,
+	}, nil
+}
+
+// CreateTestSchema creates a new test schema and runs migrations in it.
+//
+// The returned connection has search_path pinned to the test schema.
+// Closing it releases the provider's pool; the schema itself is dropped
+// with DropTestSchema or Cleanup.
+func (sm *SchemaManager) CreateTestSchema(ctx context.Context, testSchemaName ...string) (_ pgdbtemplate.DatabaseConnection, _ string, err error) {
+	var schemaName string
+	if len(testSchemaName) > 0 && testSchemaName[0] != "" {
+		schemaName = testSchemaName[0]
+	} else {
+		schemaName = fmt.Sprintf("%s%d_%d", sm.testPrefix, time.Now().UnixNano(), atomic.AddInt64(&globalTestSchemaCounter, 1))
+	}
+
+	// Connect without a pinned search_path for DDL on the schema itself.
+	adminConn, err := sm.provider.Connect(ctx, sm.databaseName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to database %q: %w", sm.databaseName, err)
+	}
+	defer adminConn.Close()
+
+	createQuery := fmt.Sprintf("CREATE SCHEMA %s", pgx.Identifier{schemaName}.Sanitize())
+	if _, err := adminConn.ExecContext(ctx, createQuery); err != nil {
+		return nil, "", fmt.Errorf("failed to create test schema %s: %w", schemaName, err)
+	}
+
+	// Drop the test schema if any further steps fail.
+	defer func() {
+		if err == nil {
+			return
+		}
+		dropQuery := fmt.Sprintf("DROP SCHEMA %s CASCADE", pgx.Identifier{schemaName}.Sanitize())
+		if _, dropErr := adminConn.ExecContext(ctx, dropQuery); dropErr != nil {
+			// Append drop error to the original error.
+			err = errors.Join(err, fmt.Errorf("failed to drop test schema %q: %w", schemaName, dropErr))
+		}
+	}()
+
+	// Connect to the new test schema.
+	testConn, err := sm.provider.ConnectSchema(ctx, sm.databaseName, schemaName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to test schema: %w", err)
+	}
+
+	// Run migrations inside the test schema.
+	if err := sm.migrator.RunMigrations(ctx, testConn); err != nil {
+		testConn.Close()
+		return nil, "", fmt.Errorf("failed to run migrations on test schema: %w", err)
+	}
+
+	// Track the created test schema for cleanup.
+	sm.createdSchemas.Store(schemaName, true)
+
+	return testConn, schemaName, nil
+}
+
+// DropTestSchema drops a test schema and everything it contains.
+func (sm *SchemaManager) DropTestSchema(ctx context.Context, schemaName string) error {
+	adminConn, err := sm.provider.Connect(ctx, sm.databaseName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database %q: %w", sm.databaseName, err)
+	}
+	defer adminConn.Close()
+
+	dropQuery := fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", pgx.Identifier{schemaName}.Sanitize())
+	if _, err := adminConn.ExecContext(ctx, dropQuery); err != nil {
+		return fmt.Errorf("failed to drop schema %s: %w", schemaName, err)
+	}
+
+	// Remove from tracking map if it was tracked.
+	sm.createdSchemas.Delete(schemaName)
+
+	return nil
+}
+
+// Cleanup removes all tracked test schemas.
+func (sm *SchemaManager) Cleanup(ctx context.Context) (errs error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	// Collect all tracked schema names to avoid modifying map
+	// during iteration.
+	var schemaNames []string
+	sm.createdSchemas.Range(func(key, value any) bool {
+		if schemaName, ok := key.(string); ok {
+			schemaNames = append(schemaNames, schemaName)
+		}
+		return true
+	})
+	if len(schemaNames) == 0 {
+		return nil // No schemas to clean up.
+	}
+
+	for _, schemaName := range schemaNames {
+		if err := sm.DropTestSchema(ctx, schemaName); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,149 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestSchemaManager(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Required fields", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		_, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.ErrorMatches, "ConnectionProvider is required")
+
+		_, err = pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+		})
+		c.Assert(err, qt.ErrorMatches, "MigrationRunner is required")
+	})
+
+	c.Run("Empty schema name is rejected", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		_, err := provider.ConnectSchema(ctx, "postgres", "")
+		c.Assert(err, qt.ErrorMatches, "schema name must not be empty")
+	})
+
+	c.Run("Test schemas are isolated", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		sm, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    createTestMigrationRunner(c),
+			TestSchemaPrefix:   fmt.Sprintf("pgx_schema_%d_%d_", time.Now().UnixNano(), os.Getpid()),
+		})
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(sm.Cleanup(ctx), qt.IsNil) }()
+
+		conn1, schema1, err := sm.CreateTestSchema(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn1.Close(), qt.IsNil) }()
+
+		conn2, schema2, err := sm.CreateTestSchema(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn2.Close(), qt.IsNil) }()
+		c.Assert(schema1, qt.Not(qt.Equals), schema2)
+
+		// Each connection resolves unqualified names in its own schema.
+		var currentSchema string
+		err = conn1.QueryRowContext(ctx, "SELECT current_schema()").Scan(&currentSchema)
+		c.Assert(err, qt.IsNil)
+		c.Assert(currentSchema, qt.Equals, schema1)
+
+		_, err = conn1.ExecContext(ctx, "INSERT INTO test_table (name) VALUES ($1)", "only_in_first")
+		c.Assert(err, qt.IsNil)
+
+		var count int
+		err = conn1.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 3)
+
+		err = conn2.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+	})
+
+	c.Run("DropTestSchema removes schema", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		sm, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    createTestMigrationRunner(c),
+		})
+		c.Assert(err, qt.IsNil)
+
+		schemaName := fmt.Sprintf("pgx_schema_drop_%d_%d", time.Now().UnixNano(), os.Getpid())
+		conn, gotName, err := sm.CreateTestSchema(ctx, schemaName)
+		c.Assert(err, qt.IsNil)
+		c.Assert(gotName, qt.Equals, schemaName)
+		c.Assert(conn.Close(), qt.IsNil)
+
+		c.Assert(sm.DropTestSchema(ctx, schemaName), qt.IsNil)
+
+		adminConn, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(adminConn.Close(), qt.IsNil) }()
+
+		var exists bool
+		err = adminConn.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", schemaName,
+		).Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsFalse)
+
+		// Nothing is left to clean up.
+		c.Assert(sm.Cleanup(ctx), qt.IsNil)
+	})
+
+	c.Run("Failed migrations drop the schema", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		tempDir := c.TempDir()
+		err := os.WriteFile(tempDir+"/001_broken.sql", []byte("CREATE TABLE ("), 0644)
+		c.Assert(err, qt.IsNil)
+
+		sm, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    pgdbtemplate.NewFileMigrationRunner([]string{tempDir}, nil),
+		})
+		c.Assert(err, qt.IsNil)
+
+		schemaName := fmt.Sprintf("pgx_schema_broken_%d_%d", time.Now().UnixNano(), os.Getpid())
+		_, _, err = sm.CreateTestSchema(ctx, schemaName)
+		c.Assert(err, qt.ErrorMatches, "failed to run migrations on test schema:.*")
+
+		adminConn, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(adminConn.Close(), qt.IsNil) }()
+
+		var exists bool
+		err = adminConn.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", schemaName,
+		).Scan(&exists)
+		c.Assert(err, qt.IsNil)
+		c.Assert(exists, qt.IsFalse)
+	})
+}
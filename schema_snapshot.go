@@ -0,0 +1,401 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+)
+
+// SchemaSnapshot is a deterministic description of the user-defined
+// objects of a database, read from the system catalogs.
+//
+// Objects in pg_catalog, information_schema and objects belonging
+// to extensions are left out; extensions are listed by version.
+type SchemaSnapshot struct {
+	Extensions []ExtensionSnapshot `json:"extensions,omitempty"`
+	Schemas    []string            `json:"schemas,omitempty"`
+	Enums      []EnumSnapshot      `json:"enums,omitempty"`
+	Types      []TypeSnapshot      `json:"types,omitempty"`
+	Sequences  []SequenceSnapshot  `json:"sequences,omitempty"`
+	Tables     []TableSnapshot     `json:"tables,omitempty"`
+	Views      []ViewSnapshot      `json:"views,omitempty"`
+	Functions  []FunctionSnapshot  `json:"functions,omitempty"`
+}
+
+// ExtensionSnapshot describes an installed extension.
+type ExtensionSnapshot struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// EnumSnapshot describes an enum type.
+type EnumSnapshot struct {
+	Schema string   `json:"schema"`
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// TypeSnapshot describes a domain, composite or range type.
+type TypeSnapshot struct {
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"` // "domain", "composite" or "range".
+	Definition string `json:"definition"`
+}
+
+// SequenceSnapshot describes a sequence.
+type SequenceSnapshot struct {
+	Schema    string `json:"schema"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Start     int64  `json:"start"`
+	Increment int64  `json:"increment"`
+	Min       int64  `json:"min"`
+	Max       int64  `json:"max"`
+	Cycle     bool   `json:"cycle,omitempty"`
+}
+
+// TableSnapshot describes a table and the objects attached to it.
+type TableSnapshot struct {
+	Schema      string               `json:"schema"`
+	Name        string               `json:"name"`
+	Columns     []ColumnSnapshot     `json:"columns,omitempty"`
+	Constraints []ConstraintSnapshot `json:"constraints,omitempty"`
+	Indexes     []IndexSnapshot      `json:"indexes,omitempty"`
+	Triggers    []TriggerSnapshot    `json:"triggers,omitempty"`
+}
+
+// ColumnSnapshot describes a table column.
+type ColumnSnapshot struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null,omitempty"`
+	Default string `json:"default,omitempty"`
+}
+
+// ConstraintSnapshot describes a table constraint.
+type ConstraintSnapshot struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// IndexSnapshot describes an index.
+type IndexSnapshot struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// TriggerSnapshot describes a trigger.
+type TriggerSnapshot struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// ViewSnapshot describes a view or materialized view.
+type ViewSnapshot struct {
+	Schema       string `json:"schema"`
+	Name         string `json:"name"`
+	Materialized bool   `json:"materialized,omitempty"`
+	Definition   string `json:"definition"`
+}
+
+// FunctionSnapshot describes a function or procedure.
+type FunctionSnapshot struct {
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Result     string `json:"result,omitempty"`
+	Definition string `json:"definition"`
+}
+
+// userNamespaces selects the namespaces holding user-defined objects.
+const userNamespaces = `
+	WITH user_namespaces AS (
+		SELECT oid, nspname FROM pg_namespace
+		WHERE nspname NOT IN ('pg_catalog', 'information_schema')
+			AND nspname NOT LIKE 'pg\_toast%' AND nspname NOT LIKE 'pg\_temp\_%'
+	)
+`
+
+// notExtensionMember filters out objects of catalog %[1]s
+// with oid %[2]s that belong to an extension.
+const notExtensionMember = `NOT EXISTS (
+	SELECT 1 FROM pg_depend dep
+	WHERE dep.classid = '%[1]s'::regclass AND dep.objid = %[2]s AND dep.deptype = 'e'
+)`
+
+// SnapshotSchema reads the schema of the database behind conn
+// from its system catalogs.
+//
+// Unlike pg_dump, only a connection to the database is needed.
+func SnapshotSchema(ctx context.Context, conn pgdbtemplate.DatabaseConnection) (*SchemaSnapshot, error) {
+	var snapshot SchemaSnapshot
+	queries := []struct {
+		what   string
+		query  string
+		target any
+	}{{
+		what: "extensions",
+		query: `
+			SELECT json_agg(json_build_object('name', extname, 'version', extversion) ORDER BY extname)
+			FROM pg_extension
+		`,
+		target: &snapshot.Extensions,
+	}, {
+		what: "schemas",
+		query: userNamespaces + `
+			SELECT json_agg(nspname ORDER BY nspname) FROM user_namespaces n
+			WHERE ` + fmt.Sprintf(notExtensionMember, "pg_namespace", "n.oid"),
+		target: &snapshot.Schemas,
+	}, {
+		what: "enums",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', t.typname,
+				'labels', (SELECT json_agg(e.enumlabel ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid)
+			) ORDER BY n.nspname, t.typname)
+			FROM pg_type t JOIN user_namespaces n ON n.oid = t.typnamespace
+			WHERE t.typtype = 'e' AND ` + fmt.Sprintf(notExtensionMember, "pg_type", "t.oid"),
+		target: &snapshot.Enums,
+	}, {
+		what: "types",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', t.typname,
+				'kind', CASE t.typtype WHEN 'd' THEN 'domain' WHEN 'c' THEN 'composite' ELSE 'range' END,
+				'definition', CASE t.typtype
+					WHEN 'd' THEN concat_ws(' ',
+						format_type(t.typbasetype, t.typtypmod),
+						CASE WHEN t.typnotnull THEN 'NOT NULL' END,
+						'DEFAULT ' || t.typdefault,
+						(SELECT string_agg(pg_get_constraintdef(co.oid), ' ' ORDER BY co.conname)
+							FROM pg_constraint co WHERE co.contypid = t.oid AND co.contype = 'c'))
+					WHEN 'c' THEN (
+						SELECT '(' || string_agg(quote_ident(a.attname) || ' ' || format_type(a.atttypid, a.atttypmod), ', ' ORDER BY a.attnum) || ')'
+						FROM pg_attribute a WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped)
+					ELSE (SELECT 'RANGE (SUBTYPE = ' || format_type(r.rngsubtype, NULL) || ')' FROM pg_range r WHERE r.rngtypid = t.oid)
+				END
+			) ORDER BY n.nspname, t.typname)
+			FROM pg_type t JOIN user_namespaces n ON n.oid = t.typnamespace
+			WHERE (t.typtype IN ('d', 'r')
+				OR (t.typtype = 'c' AND (SELECT relkind FROM pg_class WHERE oid = t.typrelid) = 'c'))
+				AND ` + fmt.Sprintf(notExtensionMember, "pg_type", "t.oid"),
+		target: &snapshot.Types,
+	}, {
+		what: "sequences",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', c.relname,
+				'type', format_type(s.seqtypid, NULL),
+				'start', s.seqstart,
+				'increment', s.seqincrement,
+				'min', s.seqmin,
+				'max', s.seqmax,
+				'cycle', s.seqcycle
+			) ORDER BY n.nspname, c.relname)
+			FROM pg_sequence s
+			JOIN pg_class c ON c.oid = s.seqrelid
+			JOIN user_namespaces n ON n.oid = c.relnamespace
+			WHERE ` + fmt.Sprintf(notExtensionMember, "pg_class", "c.oid"),
+		target: &snapshot.Sequences,
+	}, {
+		what: "tables",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', c.relname,
+				'columns', (
+					SELECT json_agg(json_build_object(
+						'name', a.attname,
+						'type', format_type(a.atttypid, a.atttypmod),
+						'not_null', a.attnotnull,
+						'default', pg_get_expr(d.adbin, d.adrelid)
+					) ORDER BY a.attnum)
+					FROM pg_attribute a
+					LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+					WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped),
+				'constraints', (
+					SELECT json_agg(json_build_object('name', co.conname, 'definition', pg_get_constraintdef(co.oid)) ORDER BY co.conname)
+					FROM pg_constraint co WHERE co.conrelid = c.oid),
+				'indexes', (
+					SELECT json_agg(json_build_object('name', ic.relname, 'definition', pg_get_indexdef(i.indexrelid)) ORDER BY ic.relname)
+					FROM pg_index i JOIN pg_class ic ON ic.oid = i.indexrelid WHERE i.indrelid = c.oid),
+				'triggers', (
+					SELECT json_agg(json_build_object('name', t.tgname, 'definition', pg_get_triggerdef(t.oid)) ORDER BY t.tgname)
+					FROM pg_trigger t WHERE t.tgrelid = c.oid AND NOT t.tgisinternal)
+			) ORDER BY n.nspname, c.relname)
+			FROM pg_class c JOIN user_namespaces n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p', 'f') AND ` + fmt.Sprintf(notExtensionMember, "pg_class", "c.oid"),
+		target: &snapshot.Tables,
+	}, {
+		what: "views",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', c.relname,
+				'materialized', c.relkind = 'm',
+				'definition', pg_get_viewdef(c.oid, true)
+			) ORDER BY n.nspname, c.relname)
+			FROM pg_class c JOIN user_namespaces n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('v', 'm') AND ` + fmt.Sprintf(notExtensionMember, "pg_class", "c.oid"),
+		target: &snapshot.Views,
+	}, {
+		what: "functions",
+		query: userNamespaces + `
+			SELECT json_agg(json_build_object(
+				'schema', n.nspname,
+				'name', p.proname,
+				'arguments', pg_get_function_identity_arguments(p.oid),
+				'result', pg_get_function_result(p.oid),
+				'definition', pg_get_functiondef(p.oid)
+			) ORDER BY n.nspname, p.proname, pg_get_function_identity_arguments(p.oid))
+			FROM pg_proc p JOIN user_namespaces n ON n.oid = p.pronamespace
+			WHERE p.prokind IN ('f', 'p') AND ` + fmt.Sprintf(notExtensionMember, "pg_proc", "p.oid"),
+		target: &snapshot.Functions,
+	}}
+
+	for _, q := range queries {
+		var data []byte
+		if err := conn.QueryRowContext(ctx, q.query).Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", q.what, err)
+		}
+		if data == nil {
+			continue // No objects of this kind.
+		}
+		if err := json.Unmarshal(data, q.target); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", q.what, err)
+		}
+	}
+	return &snapshot, nil
+}
+
+// Marshal encodes the snapshot as indented JSON, suitable for golden files.
+func (s *SchemaSnapshot) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode schema snapshot: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseSchemaSnapshot decodes a snapshot encoded by SchemaSnapshot.Marshal.
+func ParseSchemaSnapshot(data []byte) (*SchemaSnapshot, error) {
+	var snapshot SchemaSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode schema snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// SchemaChange is a single difference between two schema snapshots.
+type SchemaChange struct {
+	// Object identifies the object, e.g. "column public.users.email".
+	Object string
+	// Old and New are the definitions of the object in the compared
+	// snapshots. Old is empty for added objects, New for removed ones.
+	Old, New string
+}
+
+// String describes the change on a single line.
+func (c SchemaChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("+ %s: %s", c.Object, c.New)
+	case c.New == "":
+		return fmt.Sprintf("- %s: %s", c.Object, c.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Object, c.Old, c.New)
+}
+
+// DiffSchemas lists the differences from oldSnapshot to newSnapshot,
+// ordered by object.
+func DiffSchemas(oldSnapshot, newSnapshot *SchemaSnapshot) []SchemaChange {
+	oldObjects, newObjects := oldSnapshot.objects(), newSnapshot.objects()
+
+	var changes []SchemaChange
+	for object, oldDef := range oldObjects {
+		if newDef := newObjects[object]; newDef != oldDef {
+			changes = append(changes, SchemaChange{Object: object, Old: oldDef, New: newDef})
+		}
+	}
+	for object, newDef := range newObjects {
+		if _, exists := oldObjects[object]; !exists {
+			changes = append(changes, SchemaChange{Object: object, New: newDef})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Object < changes[j].Object })
+	return changes
+}
+
+// objects flattens the snapshot into object identifiers and non-empty
+// definitions.
+func (s *SchemaSnapshot) objects() map[string]string {
+	objects := make(map[string]string)
+	for _, e := range s.Extensions {
+		objects["extension "+e.Name] = "version " + e.Version
+	}
+	for _, name := range s.Schemas {
+		objects["schema "+name] = "exists"
+	}
+	for _, e := range s.Enums {
+		objects["enum "+e.Schema+"."+e.Name] = "(" + strings.Join(e.Labels, ", ") + ")"
+	}
+	for _, t := range s.Types {
+		objects[t.Kind+" "+t.Schema+"."+t.Name] = t.Definition
+	}
+	for _, seq := range s.Sequences {
+		def := fmt.Sprintf("%s START %d INCREMENT %d MINVALUE %d MAXVALUE %d",
+			seq.Type, seq.Start, seq.Increment, seq.Min, seq.Max)
+		if seq.Cycle {
+			def += " CYCLE"
+		}
+		objects["sequence "+seq.Schema+"."+seq.Name] = def
+	}
+	for _, t := range s.Tables {
+		table := t.Schema + "." + t.Name
+		objects["table "+table] = "exists"
+		for i, col := range t.Columns {
+			def := col.Type
+			if col.NotNull {
+				def += " NOT NULL"
+			}
+			if col.Default != "" {
+				def += " DEFAULT " + col.Default
+			}
+			objects["column "+table+"."+col.Name] = def + " (position " + strconv.Itoa(i+1) + ")"
+		}
+		for _, con := range t.Constraints {
+			objects["constraint "+table+"."+con.Name] = con.Definition
+		}
+		for _, idx := range t.Indexes {
+			objects["index "+table+"."+idx.Name] = idx.Definition
+		}
+		for _, trg := range t.Triggers {
+			objects["trigger "+table+"."+trg.Name] = trg.Definition
+		}
+	}
+	for _, v := range s.Views {
+		kind := "view "
+		if v.Materialized {
+			kind = "materialized view "
+		}
+		objects[kind+v.Schema+"."+v.Name] = singleLine(v.Definition)
+	}
+	for _, f := range s.Functions {
+		objects["function "+f.Schema+"."+f.Name+"("+f.Arguments+")"] = singleLine(f.Definition)
+	}
+	return objects
+}
+
+// singleLine collapses whitespace runs of s into single spaces.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,132 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestSnapshotSchema(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	_, err := conn.ExecContext(ctx, `
+		CREATE SCHEMA billing;
+		CREATE TYPE billing.state AS ENUM ('open', 'paid');
+		CREATE DOMAIN billing.amount AS NUMERIC(10, 2) CHECK (VALUE >= 0);
+		CREATE TABLE billing.invoices (
+			id BIGSERIAL PRIMARY KEY,
+			state billing.state NOT NULL DEFAULT 'open',
+			total billing.amount
+		);
+		CREATE INDEX invoices_state_idx ON billing.invoices (state);
+		CREATE VIEW billing.open_invoices AS SELECT id FROM billing.invoices WHERE state = 'open';
+		CREATE FUNCTION billing.touch() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END $$;
+		CREATE TRIGGER invoices_touch BEFORE UPDATE ON billing.invoices FOR EACH ROW EXECUTE FUNCTION billing.touch();
+	`)
+	c.Assert(err, qt.IsNil)
+
+	snapshot, err := pgdbtemplatepgx.SnapshotSchema(ctx, conn)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(snapshot.Schemas, qt.Contains, "billing")
+	c.Assert(snapshot.Enums, qt.DeepEquals, []pgdbtemplatepgx.EnumSnapshot{
+		{Schema: "billing", Name: "state", Labels: []string{"open", "paid"}},
+	})
+	c.Assert(snapshot.Types, qt.HasLen, 1)
+	c.Assert(snapshot.Types[0].Kind, qt.Equals, "domain")
+	c.Assert(snapshot.Types[0].Definition, qt.Matches, `numeric\(10,2\) CHECK .*`)
+
+	var invoices *pgdbtemplatepgx.TableSnapshot
+	for i, table := range snapshot.Tables {
+		if table.Schema == "billing" && table.Name == "invoices" {
+			invoices = &snapshot.Tables[i]
+		}
+	}
+	c.Assert(invoices, qt.IsNotNil)
+	c.Assert(invoices.Columns, qt.DeepEquals, []pgdbtemplatepgx.ColumnSnapshot{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('billing.invoices_id_seq'::regclass)"},
+		{Name: "state", Type: "billing.state", NotNull: true, Default: "'open'::billing.state"},
+		{Name: "total", Type: "billing.amount"},
+	})
+	c.Assert(invoices.Indexes, qt.HasLen, 2)
+	c.Assert(invoices.Indexes[1].Name, qt.Equals, "invoices_state_idx")
+	c.Assert(invoices.Triggers, qt.HasLen, 1)
+	c.Assert(invoices.Triggers[0].Name, qt.Equals, "invoices_touch")
+
+	c.Assert(snapshot.Views, qt.HasLen, 1)
+	c.Assert(snapshot.Functions, qt.HasLen, 1)
+	c.Assert(snapshot.Sequences, qt.HasLen, 2) // Including test_table's sequence.
+
+	// Snapshots survive serialization.
+	data, err := snapshot.Marshal()
+	c.Assert(err, qt.IsNil)
+	parsed, err := pgdbtemplatepgx.ParseSchemaSnapshot(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pgdbtemplatepgx.DiffSchemas(snapshot, parsed), qt.HasLen, 0)
+
+	// Snapshots are deterministic.
+	again, err := pgdbtemplatepgx.SnapshotSchema(ctx, conn)
+	c.Assert(err, qt.IsNil)
+	againData, err := again.Marshal()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(againData), qt.Equals, string(data))
+
+	_, err = conn.ExecContext(ctx, `
+		ALTER TABLE billing.invoices ADD COLUMN note TEXT;
+		DROP INDEX billing.invoices_state_idx;
+		ALTER TYPE billing.state ADD VALUE 'void';
+	`)
+	c.Assert(err, qt.IsNil)
+	changed, err := pgdbtemplatepgx.SnapshotSchema(ctx, conn)
+	c.Assert(err, qt.IsNil)
+
+	var diff []string
+	for _, change := range pgdbtemplatepgx.DiffSchemas(snapshot, changed) {
+		diff = append(diff, change.String())
+	}
+	c.Assert(diff, qt.DeepEquals, []string{
+		"+ column billing.invoices.note: text (position 4)",
+		"~ enum billing.state: (open, paid) -> (open, paid, void)",
+		"- index billing.invoices.invoices_state_idx: CREATE INDEX invoices_state_idx ON billing.invoices USING btree (state)",
+	})
+}
+
+func TestDiffSchemas(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+
+	oldSnapshot := &pgdbtemplatepgx.SchemaSnapshot{
+		Extensions: []pgdbtemplatepgx.ExtensionSnapshot{{Name: "plpgsql", Version: "1.0"}},
+		Tables: []pgdbtemplatepgx.TableSnapshot{{
+			Schema:  "public",
+			Name:    "users",
+			Columns: []pgdbtemplatepgx.ColumnSnapshot{{Name: "id", Type: "integer", NotNull: true}},
+		}},
+	}
+	newSnapshot := &pgdbtemplatepgx.SchemaSnapshot{
+		Extensions: []pgdbtemplatepgx.ExtensionSnapshot{{Name: "plpgsql", Version: "1.0"}},
+		Tables: []pgdbtemplatepgx.TableSnapshot{{
+			Schema:  "public",
+			Name:    "users",
+			Columns: []pgdbtemplatepgx.ColumnSnapshot{{Name: "id", Type: "bigint", NotNull: true}},
+		}},
+	}
+
+	c.Assert(pgdbtemplatepgx.DiffSchemas(oldSnapshot, oldSnapshot), qt.HasLen, 0)
+	c.Assert(pgdbtemplatepgx.DiffSchemas(oldSnapshot, newSnapshot), qt.DeepEquals, []pgdbtemplatepgx.SchemaChange{{
+		Object: "column public.users.id",
+		Old:    "integer NOT NULL (position 1)",
+		New:    "bigint NOT NULL (position 1)",
+	}})
+}
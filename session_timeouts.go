@@ -0,0 +1,122 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SessionTimeouts are safety timeouts applied to the sessions of a pool,
+// so that a hung statement fails with a server error naming the statement
+// instead of blocking until the test binary times out. Zero fields leave
+// the server default, which is usually no timeout.
+type SessionTimeouts struct {
+	// Statement sets statement_timeout.
+	Statement time.Duration
+	// Lock sets lock_timeout.
+	Lock time.Duration
+	// IdleInTransaction sets idle_in_transaction_session_timeout.
+	IdleInTransaction time.Duration
+}
+
+// runtimeParams returns the session timeouts as runtime parameters.
+func (t SessionTimeouts) runtimeParams() map[string]time.Duration {
+	return map[string]time.Duration{
+		"statement_timeout":                   t.Statement,
+		"lock_timeout":                        t.Lock,
+		"idle_in_transaction_session_timeout": t.IdleInTransaction,
+	}
+}
+
+// WithStatementTimeout sets statement_timeout for the sessions of every
+// pool the provider creates, except those of exempt databases.
+//
+// The admin database is always exempt, see WithTimeoutExemptDatabases,
+// but schema pools inside it, such as those of SchemaManager, are not.
+func WithStatementTimeout(d time.Duration) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.timeouts.Statement = d
+	}
+}
+
+// WithLockTimeout sets lock_timeout for the sessions of every pool the
+// provider creates, except those of exempt databases.
+func WithLockTimeout(d time.Duration) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.timeouts.Lock = d
+	}
+}
+
+// WithIdleInTransactionSessionTimeout sets
+// idle_in_transaction_session_timeout for the sessions of every pool the
+// provider creates, except those of exempt databases.
+func WithIdleInTransactionSessionTimeout(d time.Duration) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.timeouts.IdleInTransaction = d
+	}
+}
+
+// WithDatabaseTimeouts replaces the session timeouts for pools of dbName,
+// e.g. to allow a slow test more time. Overrides apply to exempt
+// databases as well.
+func WithDatabaseTimeouts(dbName string, timeouts SessionTimeouts) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.databaseTimeouts[dbName] = timeouts
+	}
+}
+
+// WithTimeoutExemptDatabases exempts pools of the given databases from the
+// session timeouts, e.g. the template database, so that long migrations
+// can finish. The admin database is always exempt.
+//
+// MigrationRunner lifts the timeouts on its own session while running
+// migrations, so templates built with it need no exemption.
+func WithTimeoutExemptDatabases(dbNames ...string) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		for _, dbName := range dbNames {
+			p.timeoutExempt[dbName] = struct{}{}
+		}
+	}
+}
+
+// applySessionTimeouts sets the session timeouts of the pool
+// identified by key as runtime parameters of config.
+func (p *ConnectionProvider) applySessionTimeouts(config *pgxpool.Config, key poolKey) {
+	timeouts, overridden := p.databaseTimeouts[key.database]
+	if !overridden {
+		if _, exempt := p.timeoutExempt[key.database]; exempt || p.isAdminPool(key) {
+			return
+		}
+		timeouts = p.timeouts
+	}
+
+	for name, d := range timeouts.runtimeParams() {
+		if d <= 0 {
+			continue
+		}
+		// PostgreSQL timeouts have millisecond resolution.
+		ms := d.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		config.ConnConfig.RuntimeParams[name] = fmt.Sprintf("%dms", ms)
+	}
+}
+
+// liftSessionTimeouts disables the session timeouts on poolConn and
+// returns a function restoring them.
+func liftSessionTimeouts(ctx context.Context, poolConn *pgxpool.Conn) (restore func() error, err error) {
+	const lift = "SET statement_timeout = 0; SET lock_timeout = 0; SET idle_in_transaction_session_timeout = 0"
+	if _, err := execUntraced(ctx, poolConn.Conn(), lift); err != nil {
+		return nil, fmt.Errorf("failed to lift session timeouts: %w", err)
+	}
+	return func() error {
+		const reset = "RESET statement_timeout; RESET lock_timeout; RESET idle_in_transaction_session_timeout"
+		if _, err := execUntraced(ctx, poolConn.Conn(), reset); err != nil {
+			return fmt.Errorf("failed to restore session timeouts: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,141 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// sessionTimeouts returns the session timeouts of conn.
+func sessionTimeouts(c *qt.C, conn pgdbtemplate.DatabaseConnection) [3]string {
+	var timeouts [3]string
+	err := conn.QueryRowContext(context.Background(),
+		"SELECT current_setting('statement_timeout'), current_setting('lock_timeout'), current_setting('idle_in_transaction_session_timeout')",
+	).Scan(&timeouts[0], &timeouts[1], &timeouts[2])
+	c.Assert(err, qt.IsNil)
+	return timeouts
+}
+
+// capturingMigrationRunner runs migrations and keeps the queries
+// captured on the template database meanwhile.
+type capturingMigrationRunner struct {
+	runner  pgdbtemplate.MigrationRunner
+	queries []pgdbtemplatepgx.CapturedQuery
+}
+
+func (r *capturingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	err := r.runner.RunMigrations(ctx, conn)
+	r.queries = conn.(*pgdbtemplatepgx.DatabaseConnection).CapturedQueries()
+	return err
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Timeouts apply to test databases", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithStatementTimeout(200*time.Millisecond),
+			pgdbtemplatepgx.WithLockTimeout(time.Second),
+			pgdbtemplatepgx.WithIdleInTransactionSessionTimeout(time.Minute),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		c.Assert(sessionTimeouts(c, conn), qt.Equals, [3]string{"200ms", "1s", "1min"})
+
+		_, err := conn.ExecContext(ctx, "SELECT pg_sleep(5)")
+		var pgErr *pgconn.PgError
+		c.Assert(errors.As(err, &pgErr), qt.IsTrue)
+		c.Assert(pgErr.Code, qt.Equals, "57014") // query_canceled
+		c.Assert(pgErr.Message, qt.Equals, "canceling statement due to statement timeout")
+
+		// The admin database is exempt.
+		admin, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(admin.Close(), qt.IsNil) }()
+		c.Assert(sessionTimeouts(c, admin), qt.Equals, [3]string{"0", "0", "0"})
+	})
+
+	c.Run("Timeouts apply to test schemas in the admin database", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithStatementTimeout(200*time.Millisecond),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		sm, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    createTestMigrationRunner(c),
+			TestSchemaPrefix:   fmt.Sprintf("pgx_timeouts_%d_%d_", time.Now().UnixNano(), os.Getpid()),
+		})
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(sm.Cleanup(ctx), qt.IsNil) }()
+
+		conn, _, err := sm.CreateTestSchema(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		c.Assert(sessionTimeouts(c, conn)[0], qt.Equals, "200ms")
+	})
+
+	c.Run("Overrides and exemptions", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithStatementTimeout(time.Second),
+			pgdbtemplatepgx.WithDatabaseTimeouts("postgres", pgdbtemplatepgx.SessionTimeouts{Lock: 2 * time.Second}),
+			pgdbtemplatepgx.WithDatabaseTimeouts("template1", pgdbtemplatepgx.SessionTimeouts{Statement: 5 * time.Second}),
+			pgdbtemplatepgx.WithTimeoutExemptDatabases("template1", "template0"),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		admin, err := provider.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(admin.Close(), qt.IsNil) }()
+		c.Assert(sessionTimeouts(c, admin), qt.Equals, [3]string{"0", "2s", "0"})
+
+		template1, err := provider.Connect(ctx, "template1")
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(template1.Close(), qt.IsNil) }()
+		c.Assert(sessionTimeouts(c, template1), qt.Equals, [3]string{"5s", "0", "0"})
+	})
+
+	c.Run("Migrations run without timeouts", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithStatementTimeout(100*time.Millisecond),
+			pgdbtemplatepgx.WithQueryCapture(),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		migrations := writeMigrations(c, map[string]string{
+			"001_slow.sql": "SELECT pg_sleep(0.3); CREATE TABLE slow (id INT);",
+		})
+		runner := &capturingMigrationRunner{runner: pgdbtemplatepgx.NewMigrationRunner([]string{migrations}, nil)}
+		tm := newTestTemplateManager(c, runner, withProvider(provider))
+		c.Assert(tm.Initialize(ctx), qt.IsNil)
+		// Lifting and restoring the timeouts is not captured.
+		for _, q := range runner.queries {
+			c.Assert(q.SQL, qt.Not(qt.Matches), `(?s).*statement_timeout.*`)
+		}
+
+		conn, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() {
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+		}()
+		c.Assert(sessionTimeouts(c, conn)[0], qt.Equals, "100ms")
+	})
+}
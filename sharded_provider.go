@@ -0,0 +1,329 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+)
+
+// virtualNodesPerShard is the number of points every shard occupies
+// on the consistent hashing ring.
+const virtualNodesPerShard = 64
+
+// globalShardedTestDBCounter is a global atomic counter for unique test
+// database names across all sharded providers.
+var globalShardedTestDBCounter int64
+
+// ShardingStrategy selects the server a new test database is placed on.
+type ShardingStrategy int
+
+const (
+	// LeastLoadedSharding places a test database on the server with the
+	// fewest live test databases created by the provider.
+	LeastLoadedSharding ShardingStrategy = iota
+	// ConsistentHashSharding places a test database on the server chosen
+	// by consistent hashing of its name, so that the same name always
+	// lands on the same server for a given set of servers.
+	ConsistentHashSharding
+)
+
+// ShardedConfig holds configuration for the sharded provider.
+type ShardedConfig struct {
+	// ConnectionStrings are the base connection strings of the servers,
+	// one per server.
+	//
+	// This field is required.
+	ConnectionStrings []string
+	// MigrationRunner runs migrations on the template database of
+	// every server.
+	//
+	// This field is required.
+	MigrationRunner pgdbtemplate.MigrationRunner
+	// TemplateName is the base name of the template databases.
+	//
+	// The template on the server with index i is named TemplateName
+	// followed by "_shard<i>", so that several shards may point to the
+	// same server. If empty, a unique name will be generated.
+	TemplateName string
+	// TestDBPrefix is the prefix for test database names.
+	//
+	// If empty, "test_" will be used.
+	TestDBPrefix string
+	// AdminDBName is the name of the administrative database on
+	// every server.
+	//
+	// If empty, "postgres" will be used.
+	AdminDBName string
+	// Strategy selects the server for new test databases.
+	//
+	// If not set, LeastLoadedSharding will be used.
+	Strategy ShardingStrategy
+	// ConnectionOptions are applied to the connection provider
+	// of every server.
+	ConnectionOptions []ConnectionOption
+}
+
+// shard is a single server of a sharded provider.
+type shard struct {
+	index        int
+	provider     *ConnectionProvider
+	tm           *pgdbtemplate.TemplateManager
+	templateName string
+	load         atomic.Int64 // Live test databases created on the shard.
+}
+
+// ringPoint is a virtual node of a shard on the consistent hashing ring.
+type ringPoint struct {
+	hash  uint32
+	shard *shard
+}
+
+// ShardedProvider spreads test databases across several PostgreSQL servers.
+//
+// It initializes the template database on every server and places each
+// test database on one of them according to the configured strategy.
+// ShardedProvider implements pgdbtemplate.ConnectionProvider: Connect routes
+// the test and template databases it created to the server owning them, so
+// callers connect to them through the unchanged interface.
+//
+// Connect fails for any other database, including the administrative one,
+// which exists on every server. ShardedProvider therefore cannot serve as
+// the ConnectionProvider of a pgdbtemplate.TemplateManager, which creates
+// databases through the administrative database; test databases must be
+// created with its own CreateTestDatabase.
+type ShardedProvider struct {
+	shards     []*shard
+	ring       []ringPoint
+	strategy   ShardingStrategy
+	testPrefix string
+
+	mu     sync.RWMutex
+	owners map[string]*shard // Test database name to owning shard.
+}
+
+// NewShardedProvider creates a new sharded provider with one connection
+// provider and template manager per server.
+func NewShardedProvider(config ShardedConfig) (*ShardedProvider, error) {
+	// Validate required fields.
+	if len(config.ConnectionStrings) == 0 {
+		return nil, fmt.Errorf("at least one connection string is required")
+	}
+	if config.MigrationRunner == nil {
+		return nil, fmt.Errorf("MigrationRunner is required")
+	}
+
+	templateName := config.TemplateName
+	if templateName == "" {
+		templateName = fmt.Sprintf("template_db_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalShardedTestDBCounter, 1))
+	}
+
+	testPrefix := config.TestDBPrefix
+	if testPrefix == "" {
+		testPrefix = "test_"
+	}
+
+	sp := &ShardedProvider{
+		strategy:   config.Strategy,
+		testPrefix: testPrefix,
+		owners:     make(map[string]*shard),
+	}
+	for i, baseConnString := range config.ConnectionStrings {
+		baseConnString := baseConnString
+		provider := NewConnectionProvider(func(dbName string) string {
+			return pgdbtemplate.ReplaceDatabaseInConnectionString(baseConnString, dbName)
+		}, config.ConnectionOptions...)
+
+		shardTemplateName := fmt.Sprintf("%s_shard%d", templateName, i)
+		tm, err := pgdbtemplate.NewTemplateManager(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    config.MigrationRunner,
+			TemplateName:       shardTemplateName,
+			TestDBPrefix:       testPrefix,
+			AdminDBName:        config.AdminDBName,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create template manager for shard %d: %w", i, err)
+		}
+
+		s := &shard{index: i, provider: provider, tm: tm, templateName: shardTemplateName}
+		sp.shards = append(sp.shards, s)
+		for v := 0; v < virtualNodesPerShard; v++ {
+			sp.ring = append(sp.ring, ringPoint{hash: hashName(fmt.Sprintf("%d#%d", i, v)), shard: s})
+		}
+	}
+	sort.Slice(sp.ring, func(i, j int) bool { return sp.ring[i].hash < sp.ring[j].hash })
+	return sp, nil
+}
+
+// Initialize sets up the template database on every server concurrently.
+func (sp *ShardedProvider) Initialize(ctx context.Context) error {
+	errs := make([]error, len(sp.shards))
+	var wg sync.WaitGroup
+	for _, s := range sp.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			if err := s.tm.Initialize(ctx); err != nil {
+				errs[s.index] = fmt.Errorf("failed to initialize shard %d: %w", s.index, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CreateTestDatabase creates a new test database from the template on the
+// server selected by the sharding strategy.
+//
+// The caller is expected to call Initialize() before using this method.
+func (sp *ShardedProvider) CreateTestDatabase(ctx context.Context, testDBName ...string) (pgdbtemplate.DatabaseConnection, string, error) {
+	var dbName string
+	if len(testDBName) > 0 && testDBName[0] != "" {
+		dbName = testDBName[0]
+	} else {
+		dbName = fmt.Sprintf("%s%d_%d", sp.testPrefix, time.Now().UnixNano(), atomic.AddInt64(&globalShardedTestDBCounter, 1))
+	}
+
+	// Pick and reserve the shard atomically so that concurrent
+	// callers see each other's load.
+	sp.mu.Lock()
+	s := sp.pickShard(dbName)
+	s.load.Add(1)
+	sp.mu.Unlock()
+
+	conn, _, err := s.tm.CreateTestDatabase(ctx, dbName)
+	if err != nil {
+		s.load.Add(-1)
+		return nil, "", fmt.Errorf("failed to create test database on shard %d: %w", s.index, err)
+	}
+
+	sp.mu.Lock()
+	sp.owners[dbName] = s
+	sp.mu.Unlock()
+	return conn, dbName, nil
+}
+
+// DropTestDatabase drops a test database on the server owning it.
+func (sp *ShardedProvider) DropTestDatabase(ctx context.Context, dbName string) error {
+	sp.mu.RLock()
+	s, exists := sp.owners[dbName]
+	sp.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("test database %q is not owned by any shard", dbName)
+	}
+
+	if err := s.tm.DropTestDatabase(ctx, dbName); err != nil {
+		return fmt.Errorf("failed to drop test database on shard %d: %w", s.index, err)
+	}
+
+	sp.mu.Lock()
+	delete(sp.owners, dbName)
+	sp.mu.Unlock()
+	s.load.Add(-1)
+	return nil
+}
+
+// Cleanup removes all tracked test databases and the template databases
+// on every server.
+func (sp *ShardedProvider) Cleanup(ctx context.Context) (errs error) {
+	for _, s := range sp.shards {
+		if err := s.tm.Cleanup(ctx); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to clean up shard %d: %w", s.index, err))
+		}
+	}
+
+	sp.mu.Lock()
+	sp.owners = make(map[string]*shard)
+	sp.mu.Unlock()
+	for _, s := range sp.shards {
+		s.load.Store(0)
+	}
+	return errs
+}
+
+// Shard returns the index of the server owning the test database dbName.
+func (sp *ShardedProvider) Shard(dbName string) (int, bool) {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+
+	s, exists := sp.owners[dbName]
+	if !exists {
+		return 0, false
+	}
+	return s.index, true
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.Connect.
+//
+// Test databases and template databases are routed to the server owning
+// them. Connecting to any other database, including the administrative one,
+// fails, since it is not clear which server is meant.
+func (sp *ShardedProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	sp.mu.RLock()
+	s, exists := sp.owners[databaseName]
+	sp.mu.RUnlock()
+	if !exists {
+		for _, candidate := range sp.shards {
+			if candidate.templateName == databaseName {
+				s, exists = candidate, true
+				break
+			}
+		}
+	}
+	if !exists {
+		return nil, fmt.Errorf("database %q is not owned by any shard; create test databases with CreateTestDatabase", databaseName)
+	}
+	return s.provider.Connect(ctx, databaseName)
+}
+
+// GetNoRowsSentinel implements pgdbtemplate.ConnectionProvider.GetNoRowsSentinel.
+func (*ShardedProvider) GetNoRowsSentinel() error {
+	return pgx.ErrNoRows
+}
+
+// Close closes all connection pools of every server.
+func (sp *ShardedProvider) Close() {
+	for _, s := range sp.shards {
+		s.provider.Close()
+	}
+}
+
+// pickShard selects the shard for a new test database.
+func (sp *ShardedProvider) pickShard(dbName string) *shard {
+	if sp.strategy == ConsistentHashSharding {
+		return sp.hashShard(dbName)
+	}
+
+	best := sp.shards[0]
+	for _, s := range sp.shards[1:] {
+		if s.load.Load() < best.load.Load() {
+			best = s
+		}
+	}
+	return best
+}
+
+// hashShard returns the shard owning name on the consistent hashing ring.
+func (sp *ShardedProvider) hashShard(name string) *shard {
+	h := hashName(name)
+	i := sort.Search(len(sp.ring), func(i int) bool { return sp.ring[i].hash >= h })
+	if i == len(sp.ring) {
+		i = 0 // Wrap around the ring.
+	}
+	return sp.ring[i].shard
+}
+
+// hashName hashes name for the consistent hashing ring.
+func hashName(name string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return h.Sum32()
+}
@@ -0,0 +1,161 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// Compile-time check that the sharded provider keeps the
+// pgdbtemplate.ConnectionProvider interface.
+var _ pgdbtemplate.ConnectionProvider = (*pgdbtemplatepgx.ShardedProvider)(nil)
+
+// shardConnectionString returns testConnectionString with the
+// application_name set to tell the sessions of shard apart.
+func shardConnectionString(c *qt.C, shard int) string {
+	name := fmt.Sprintf("pgx_shard%d", shard)
+	if !strings.HasPrefix(testConnectionString, "postgres://") && !strings.HasPrefix(testConnectionString, "postgresql://") {
+		return testConnectionString + " application_name=" + name
+	}
+
+	u, err := url.Parse(testConnectionString)
+	c.Assert(err, qt.IsNil)
+	query := u.Query()
+	query.Set("application_name", name)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func TestShardedProvider(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Both shards point to the same server, which is enough to exercise
+	// placement and routing: every shard has its own template database,
+	// and its sessions are told apart by their application_name.
+	newShardedProvider := func(c *qt.C, strategy pgdbtemplatepgx.ShardingStrategy) *pgdbtemplatepgx.ShardedProvider {
+		sp, err := pgdbtemplatepgx.NewShardedProvider(pgdbtemplatepgx.ShardedConfig{
+			ConnectionStrings: []string{shardConnectionString(c, 0), shardConnectionString(c, 1)},
+			MigrationRunner:   createTestMigrationRunner(c),
+			TemplateName:      fmt.Sprintf("pgx_sharded_template_%d_%d", time.Now().UnixNano(), os.Getpid()),
+			TestDBPrefix:      fmt.Sprintf("pgx_sharded_%d_%d_", time.Now().UnixNano(), os.Getpid()),
+			Strategy:          strategy,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(sp.Initialize(ctx), qt.IsNil)
+		c.Cleanup(func() {
+			sp.Close()
+			c.Check(sp.Cleanup(ctx), qt.IsNil)
+		})
+		return sp
+	}
+
+	c.Run("Required fields", func(c *qt.C) {
+		_, err := pgdbtemplatepgx.NewShardedProvider(pgdbtemplatepgx.ShardedConfig{
+			MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.ErrorMatches, "at least one connection string is required")
+
+		_, err = pgdbtemplatepgx.NewShardedProvider(pgdbtemplatepgx.ShardedConfig{
+			ConnectionStrings: []string{testConnectionString},
+		})
+		c.Assert(err, qt.ErrorMatches, "MigrationRunner is required")
+	})
+
+	c.Run("Least loaded placement balances shards", func(c *qt.C) {
+		c.Parallel()
+		sp := newShardedProvider(c, pgdbtemplatepgx.LeastLoadedSharding)
+
+		perShard := make(map[int]int)
+		for i := 0; i < 4; i++ {
+			conn, dbName, err := sp.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+
+			shard, ok := sp.Shard(dbName)
+			c.Assert(ok, qt.IsTrue)
+			perShard[shard]++
+		}
+		c.Assert(perShard, qt.DeepEquals, map[int]int{0: 2, 1: 2})
+	})
+
+	c.Run("Connect routes to the owning shard", func(c *qt.C) {
+		c.Parallel()
+		sp := newShardedProvider(c, pgdbtemplatepgx.LeastLoadedSharding)
+
+		// Least loaded placement puts one database on each shard.
+		for i := 0; i < 2; i++ {
+			conn, dbName, err := sp.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+			shard, ok := sp.Shard(dbName)
+			c.Assert(ok, qt.IsTrue)
+
+			routed, err := sp.Connect(ctx, dbName)
+			c.Assert(err, qt.IsNil)
+
+			var (
+				currentDB, applicationName string
+				count                      int
+			)
+			err = routed.QueryRowContext(ctx,
+				"SELECT current_database(), current_setting('application_name'), (SELECT COUNT(*) FROM test_table)",
+			).Scan(&currentDB, &applicationName, &count)
+			c.Assert(err, qt.IsNil)
+			c.Assert(currentDB, qt.Equals, dbName)
+			c.Assert(applicationName, qt.Equals, fmt.Sprintf("pgx_shard%d", shard))
+			c.Assert(count, qt.Equals, 2)
+			c.Assert(routed.Close(), qt.IsNil)
+		}
+
+		// The administrative database exists on every shard.
+		_, err := sp.Connect(ctx, "postgres")
+		c.Assert(err, qt.ErrorMatches, `database "postgres" is not owned by any shard; create test databases with CreateTestDatabase`)
+	})
+
+	c.Run("Consistent hashing is deterministic", func(c *qt.C) {
+		c.Parallel()
+		sp1 := newShardedProvider(c, pgdbtemplatepgx.ConsistentHashSharding)
+		sp2 := newShardedProvider(c, pgdbtemplatepgx.ConsistentHashSharding)
+
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("pgx_sharded_hash_%d_%d_%d", time.Now().UnixNano(), os.Getpid(), i)
+
+			conn, _, err := sp1.CreateTestDatabase(ctx, name)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+			shard1, _ := sp1.Shard(name)
+			c.Assert(sp1.DropTestDatabase(ctx, name), qt.IsNil)
+
+			conn, _, err = sp2.CreateTestDatabase(ctx, name)
+			c.Assert(err, qt.IsNil)
+			c.Assert(conn.Close(), qt.IsNil)
+			shard2, _ := sp2.Shard(name)
+			c.Assert(sp2.DropTestDatabase(ctx, name), qt.IsNil)
+
+			c.Assert(shard1, qt.Equals, shard2)
+		}
+	})
+
+	c.Run("DropTestDatabase of unknown database", func(c *qt.C) {
+		sp, err := pgdbtemplatepgx.NewShardedProvider(pgdbtemplatepgx.ShardedConfig{
+			ConnectionStrings: []string{testConnectionString},
+			MigrationRunner:   &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.IsNil)
+		defer sp.Close()
+
+		err = sp.DropTestDatabase(ctx, "unknown_db")
+		c.Assert(err, qt.ErrorMatches, `test database "unknown_db" is not owned by any shard`)
+	})
+}
@@ -0,0 +1,340 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Defaults of SlowQueryConfig.
+const (
+	defaultSlowQueryThreshold   = 100 * time.Millisecond
+	defaultSlowQueryMaxReported = 10
+	lockSampleTimeout           = 5 * time.Second
+)
+
+// SlowQueryConfig configures the slow query report of a provider.
+type SlowQueryConfig struct {
+	// Threshold is the duration above which statements are reported.
+	// It defaults to 100ms.
+	Threshold time.Duration
+
+	// LockWaitLimit is the duration after which a statement that is still
+	// running is checked for lock waits, sampling pg_stat_activity and
+	// pg_locks. Zero disables sampling.
+	LockWaitLimit time.Duration
+
+	// MaxReported limits the statements and lock waits listed in the
+	// report to the worst ones. It defaults to 10.
+	MaxReported int
+
+	// Output receives the report at Shutdown. It defaults to os.Stderr.
+	Output io.Writer
+}
+
+// WithSlowQueryReport makes the provider track statements slower than
+// config.Threshold and lock waits on all its pools, and write a summary
+// of the worst offenders with their database names to config.Output at
+// Shutdown. Nothing is written if no statement was slow. Statements on
+// the admin pool, such as CREATE DATABASE, are not tracked, unlike those
+// on schema pools inside the admin database.
+//
+// Slow statements often point at missing indexes or lock contention that
+// would hurt in production as well.
+func WithSlowQueryReport(config SlowQueryConfig) ConnectionOption {
+	if config.Threshold <= 0 {
+		config.Threshold = defaultSlowQueryThreshold
+	}
+	if config.MaxReported <= 0 {
+		config.MaxReported = defaultSlowQueryMaxReported
+	}
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	return func(p *ConnectionProvider) {
+		p.slowQueries = &slowQueryReporter{
+			config:     config,
+			provider:   p,
+			statements: make(map[slowStatementKey]*SlowStatement),
+		}
+	}
+}
+
+// SlowQueryReport summarizes the slow statements and lock waits
+// of a provider.
+type SlowQueryReport struct {
+	Threshold  time.Duration
+	Statements []SlowStatement // Slowest first.
+	LockWaits  []LockWait      // Longest first.
+}
+
+// SlowStatement aggregates the slow executions of a statement
+// on one database.
+type SlowStatement struct {
+	Database string
+	SQL      string
+	Count    int
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// LockWait is a statement found waiting for a lock by sampling
+// pg_stat_activity and pg_locks.
+//
+// Samples are taken on the admin database, so relations are given by
+// their OID in Database.
+type LockWait struct {
+	Database      string
+	SQL           string
+	PID           uint32
+	Waited        time.Duration // Running time when sampled.
+	WaitEventType string
+	WaitEvent     string
+	Locks         []string // Locks waited for, e.g. "relation 16384: AccessExclusiveLock".
+	BlockedBy     []BlockingSession
+}
+
+// BlockingSession is a session holding a lock another one waits for.
+type BlockingSession struct {
+	PID      uint32 `json:"pid"`
+	Database string `json:"database"`
+	State    string `json:"state"`
+	Query    string `json:"query"`
+}
+
+// String formats the report for humans.
+func (r *SlowQueryReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "slow query report: %d statements slower than %s, %d lock waits\n",
+		len(r.Statements), r.Threshold, len(r.LockWaits))
+	if len(r.Statements) > 0 {
+		b.WriteString("\nslowest statements:\n")
+		for i, s := range r.Statements {
+			fmt.Fprintf(&b, "%3d. %s max, %d calls, %s total [%s]\n     %s\n",
+				i+1, s.Max.Round(time.Millisecond), s.Count, s.Total.Round(time.Millisecond), s.Database, singleLine(s.SQL))
+		}
+	}
+	if len(r.LockWaits) > 0 {
+		b.WriteString("\nlock waits:\n")
+		for i, w := range r.LockWaits {
+			fmt.Fprintf(&b, "%3d. pid %d waited %s on %s/%s [%s]\n     %s\n",
+				i+1, w.PID, w.Waited.Round(time.Millisecond), w.WaitEventType, w.WaitEvent, w.Database, singleLine(w.SQL))
+			for _, lock := range w.Locks {
+				fmt.Fprintf(&b, "     waiting for %s\n", lock)
+			}
+			for _, s := range w.BlockedBy {
+				fmt.Fprintf(&b, "     blocked by pid %d (%s) [%s]: %s\n", s.PID, s.State, s.Database, singleLine(s.Query))
+			}
+		}
+	}
+	return b.String()
+}
+
+// SlowQueryReport returns the slow statements and lock waits recorded so
+// far. It returns nil unless the provider was created with
+// WithSlowQueryReport.
+func (p *ConnectionProvider) SlowQueryReport() *SlowQueryReport {
+	if p.slowQueries == nil {
+		return nil
+	}
+	return p.slowQueries.report()
+}
+
+// slowStatementKey identifies a statement on a database.
+type slowStatementKey struct {
+	database string
+	sql      string
+}
+
+// slowQueryReporter records slow statements and lock waits.
+type slowQueryReporter struct {
+	config   SlowQueryConfig
+	provider *ConnectionProvider
+
+	// samples tracks running lock wait samplers.
+	samples sync.WaitGroup
+
+	mu         sync.Mutex
+	statements map[slowStatementKey]*SlowStatement
+	lockWaits  []LockWait
+}
+
+// tracePool installs a tracer reporting slow statements on dbName.
+func (r *slowQueryReporter) tracePool(config *pgxpool.Config, dbName string) {
+	config.ConnConfig.Tracer = &slowQueryTracer{
+		reporter: r,
+		dbName:   dbName,
+		next:     config.ConnConfig.Tracer,
+	}
+}
+
+// recordStatement records an execution of sql on dbName.
+func (r *slowQueryReporter) recordStatement(dbName, sql string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := slowStatementKey{database: dbName, sql: sql}
+	s, ok := r.statements[key]
+	if !ok {
+		s = &SlowStatement{Database: dbName, SQL: sql}
+		r.statements[key] = s
+	}
+	s.Count++
+	s.Total += duration
+	if duration > s.Max {
+		s.Max = duration
+	}
+}
+
+// sampleLocks records a lock wait if the backend pid running sql on
+// dbName waits for a lock.
+func (r *slowQueryReporter) sampleLocks(dbName string, pid uint32, sql string, waited time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockSampleTimeout)
+	defer cancel()
+
+	// Sample through the admin pool rather than a connection to dbName,
+	// which would keep Fork, Checkpoint and drops of dbName from running.
+	conn, err := r.provider.connect(ctx, poolKey{database: r.provider.adminDBName})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	query := `
+		SELECT
+			COALESCE(a.wait_event_type, ''),
+			COALESCE(a.wait_event, ''),
+			COALESCE((
+				SELECT array_agg(l.locktype || COALESCE(' ' || l.relation::text, '') || ': ' || l.mode)
+				FROM pg_locks l WHERE l.pid = a.pid AND NOT l.granted
+			), '{}'),
+			COALESCE((
+				SELECT json_agg(json_build_object(
+					'pid', b.pid, 'database', b.datname, 'state', b.state, 'query', b.query
+				) ORDER BY b.pid)
+				FROM pg_stat_activity b WHERE b.pid = ANY(pg_blocking_pids(a.pid))
+			), '[]')
+		FROM pg_stat_activity a
+		WHERE a.pid = $1
+	`
+	wait := LockWait{Database: dbName, SQL: sql, PID: pid, Waited: waited}
+	var blockedBy []byte
+	err = conn.Pool.QueryRow(ctx, query, pid).Scan(&wait.WaitEventType, &wait.WaitEvent, &wait.Locks, &blockedBy)
+	if err != nil {
+		return // The statement finished in the meantime.
+	}
+	if err := json.Unmarshal(blockedBy, &wait.BlockedBy); err != nil {
+		return
+	}
+	if wait.WaitEventType != "Lock" && len(wait.BlockedBy) == 0 {
+		return // Slow, but not waiting for a lock.
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lockWaits = append(r.lockWaits, wait)
+}
+
+// report returns the worst slow statements and lock waits.
+func (r *slowQueryReporter) report() *SlowQueryReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &SlowQueryReport{Threshold: r.config.Threshold}
+	for _, s := range r.statements {
+		report.Statements = append(report.Statements, *s)
+	}
+	sort.Slice(report.Statements, func(i, j int) bool {
+		a, b := report.Statements[i], report.Statements[j]
+		if a.Max != b.Max {
+			return a.Max > b.Max
+		}
+		return a.Database+a.SQL < b.Database+b.SQL
+	})
+	if len(report.Statements) > r.config.MaxReported {
+		report.Statements = report.Statements[:r.config.MaxReported]
+	}
+
+	report.LockWaits = append(report.LockWaits, r.lockWaits...)
+	sort.SliceStable(report.LockWaits, func(i, j int) bool {
+		return report.LockWaits[i].Waited > report.LockWaits[j].Waited
+	})
+	if len(report.LockWaits) > r.config.MaxReported {
+		report.LockWaits = report.LockWaits[:r.config.MaxReported]
+	}
+	return report
+}
+
+// writeReport waits for running samplers and writes the report
+// to the configured output, unless there is nothing to report.
+func (r *slowQueryReporter) writeReport() error {
+	r.samples.Wait()
+
+	report := r.report()
+	if len(report.Statements) == 0 && len(report.LockWaits) == 0 {
+		return nil
+	}
+	if _, err := io.WriteString(r.config.Output, report.String()); err != nil {
+		return fmt.Errorf("failed to write slow query report: %w", err)
+	}
+	return nil
+}
+
+// slowQueryTracer reports slow statements of a pool.
+type slowQueryTracer struct {
+	reporter *slowQueryReporter
+	dbName   string
+	next     pgx.QueryTracer
+}
+
+// slowQueryTraceKey is the context key of the statement being traced.
+type slowQueryTraceKey struct{}
+
+// slowQueryTrace is a statement being traced.
+type slowQueryTrace struct {
+	sql     string
+	start   time.Time
+	sampler *time.Timer
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if t.next != nil {
+		ctx = t.next.TraceQueryStart(ctx, conn, data)
+	}
+
+	trace := &slowQueryTrace{sql: data.SQL, start: time.Now()}
+	if limit := t.reporter.config.LockWaitLimit; limit > 0 {
+		pid := conn.PgConn().PID()
+		t.reporter.samples.Add(1)
+		trace.sampler = time.AfterFunc(limit, func() {
+			defer t.reporter.samples.Done()
+			t.reporter.sampleLocks(t.dbName, pid, data.SQL, time.Since(trace.start))
+		})
+	}
+	return context.WithValue(ctx, slowQueryTraceKey{}, trace)
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if trace, ok := ctx.Value(slowQueryTraceKey{}).(*slowQueryTrace); ok {
+		if trace.sampler != nil && trace.sampler.Stop() {
+			t.reporter.samples.Done()
+		}
+		if duration := time.Since(trace.start); duration > t.reporter.config.Threshold {
+			t.reporter.recordStatement(t.dbName, trace.sql, duration)
+		}
+	}
+	if t.next != nil {
+		t.next.TraceQueryEnd(ctx, conn, data)
+	}
+}
@@ -0,0 +1,113 @@
+package pgdbtemplatepgx_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+func TestSlowQueryReport(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Report slow statements and lock waits", func(c *qt.C) {
+		c.Parallel()
+		var output bytes.Buffer
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithSlowQueryReport(pgdbtemplatepgx.SlowQueryConfig{
+				Threshold:     50 * time.Millisecond,
+				LockWaitLimit: 200 * time.Millisecond,
+				Output:        &output,
+			}))
+
+		conn, dbName := createTestDatabase(c, provider)
+		pgxConn := conn.(*pgdbtemplatepgx.DatabaseConnection)
+
+		for i := 0; i < 2; i++ {
+			_, err := conn.ExecContext(ctx, "SELECT pg_sleep(0.1)")
+			c.Assert(err, qt.IsNil)
+		}
+		_, err := conn.ExecContext(ctx, "SELECT 1")
+		c.Assert(err, qt.IsNil)
+
+		// Block a query on a lock held by another transaction.
+		tx, err := pgxConn.Pool.Begin(ctx)
+		c.Assert(err, qt.IsNil)
+		_, err = tx.Exec(ctx, "LOCK TABLE test_table IN ACCESS EXCLUSIVE MODE")
+		c.Assert(err, qt.IsNil)
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			_ = tx.Rollback(ctx)
+		}()
+		_, err = conn.ExecContext(ctx, "SELECT COUNT(*) FROM test_table")
+		c.Assert(err, qt.IsNil)
+
+		report := provider.SlowQueryReport()
+		var sleeps *pgdbtemplatepgx.SlowStatement
+		for i, s := range report.Statements {
+			c.Assert(s.SQL, qt.Not(qt.Equals), "SELECT 1")
+			if s.SQL == "SELECT pg_sleep(0.1)" {
+				sleeps = &report.Statements[i]
+			}
+		}
+		c.Assert(sleeps, qt.Not(qt.IsNil))
+		c.Assert(sleeps.Database, qt.Equals, dbName)
+		c.Assert(sleeps.Count, qt.Equals, 2)
+		c.Assert(sleeps.Max >= 100*time.Millisecond, qt.IsTrue)
+
+		c.Assert(report.LockWaits, qt.HasLen, 1)
+		wait := report.LockWaits[0]
+		c.Assert(wait.Database, qt.Equals, dbName)
+		c.Assert(wait.SQL, qt.Equals, "SELECT COUNT(*) FROM test_table")
+		c.Assert(wait.WaitEventType, qt.Equals, "Lock")
+		var tableOID uint32
+		c.Assert(conn.QueryRowContext(ctx, "SELECT 'test_table'::regclass::oid").Scan(&tableOID), qt.IsNil)
+		lock := fmt.Sprintf("relation %d: AccessShareLock", tableOID)
+		c.Assert(wait.Locks, qt.DeepEquals, []string{lock})
+		c.Assert(wait.BlockedBy, qt.HasLen, 1)
+		c.Assert(wait.BlockedBy[0].State, qt.Equals, "idle in transaction")
+		c.Assert(wait.BlockedBy[0].Query, qt.Equals, "LOCK TABLE test_table IN ACCESS EXCLUSIVE MODE")
+
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(provider.Shutdown(ctx), qt.IsNil)
+		c.Assert(output.String(), qt.Contains, "slow query report:")
+		c.Assert(output.String(), qt.Contains, "SELECT pg_sleep(0.1)")
+		c.Assert(output.String(), qt.Contains, "waiting for "+lock)
+	})
+
+	c.Run("Nothing to report", func(c *qt.C) {
+		c.Parallel()
+		var output bytes.Buffer
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithSlowQueryReport(pgdbtemplatepgx.SlowQueryConfig{
+				Threshold: time.Minute,
+				Output:    &output,
+			}))
+
+		conn, _ := createTestDatabase(c, provider)
+		_, err := conn.ExecContext(ctx, "SELECT 1")
+		c.Assert(err, qt.IsNil)
+		c.Assert(conn.Close(), qt.IsNil)
+
+		c.Assert(provider.Shutdown(ctx), qt.IsNil)
+		c.Assert(output.String(), qt.Equals, "")
+	})
+
+	c.Run("Default threshold", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithSlowQueryReport(pgdbtemplatepgx.SlowQueryConfig{}))
+		c.Assert(provider.SlowQueryReport().Threshold, qt.Equals, 100*time.Millisecond)
+	})
+
+	c.Run("Disabled", func(c *qt.C) {
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		c.Assert(provider.SlowQueryReport(), qt.IsNil)
+	})
+}
@@ -0,0 +1,172 @@
+package pgdbtemplatepgx
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sqlStatement is a single statement of a SQL script.
+type sqlStatement struct {
+	text   string
+	offset int // Byte offset of text within the script.
+}
+
+// splitStatements splits a SQL script into statements at top-level
+// semicolons.
+//
+// Semicolons inside comments, string literals, quoted identifiers and
+// dollar-quoted bodies do not split. Statements that consist only of
+// whitespace and comments are dropped.
+func splitStatements(script string) []sqlStatement {
+	var (
+		statements []sqlStatement
+		start      int
+		hasCode    bool
+	)
+	flush := func(end int) {
+		if hasCode {
+			statements = append(statements, sqlStatement{text: script[start:end], offset: start})
+		}
+		start = end
+		hasCode = false
+	}
+
+	for i := 0; i < len(script); {
+		switch ch := script[i]; {
+		case ch == '-' && strings.HasPrefix(script[i:], "--"):
+			// Line comment.
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+				continue
+			}
+			i += end + 1
+		case ch == '/' && strings.HasPrefix(script[i:], "/*"):
+			// Block comment, possibly nested.
+			i = skipBlockComment(script, i)
+		case ch == '\'':
+			hasCode = true
+			// Backslash escapes are only honored in E'...' strings.
+			escapes := i > 0 && (script[i-1] == 'E' || script[i-1] == 'e') && (i < 2 || !isIdentChar(script[i-2]))
+			i = skipQuoted(script, i, '\'', escapes)
+		case ch == '"':
+			hasCode = true
+			i = skipQuoted(script, i, '"', false)
+		case ch == '$':
+			hasCode = true
+			if tag, ok := dollarQuoteTag(script, i); ok {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end < 0 {
+					i = len(script)
+					continue
+				}
+				i += len(tag) + end + len(tag)
+				continue
+			}
+			i++
+		case ch == ';':
+			i++
+			flush(i)
+		default:
+			if !isSpace(ch) {
+				hasCode = true
+			}
+			i++
+		}
+	}
+	flush(len(script))
+	return statements
+}
+
+// skipBlockComment returns the index just past the block comment starting at i.
+func skipBlockComment(script string, i int) int {
+	depth := 0
+	for i < len(script) {
+		switch {
+		case strings.HasPrefix(script[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(script[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted returns the index just past the quoted literal starting at i.
+//
+// Doubled quotes are treated as escaped quotes.
+func skipQuoted(script string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(script); i++ {
+		switch script[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+// dollarQuoteTag returns the dollar-quote tag, e.g. "$body$", starting at i.
+func dollarQuoteTag(script string, i int) (string, bool) {
+	// A dollar following an identifier character is part of the
+	// identifier or a positional parameter, not a quote.
+	if i > 0 && isIdentChar(script[i-1]) {
+		return "", false
+	}
+	for j := i + 1; j < len(script); j++ {
+		switch ch := script[j]; {
+		case ch == '$':
+			return script[i : j+1], true
+		case isIdentChar(ch) && !(j == i+1 && ch >= '0' && ch <= '9'):
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isIdentChar reports whether ch may appear in an unquoted identifier.
+func isIdentChar(ch byte) bool {
+	return ch == '_' || ch >= 0x80 ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// isSpace reports whether ch is SQL whitespace.
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f' || ch == '\v'
+}
+
+// lineColumn converts the 1-based character position reported by
+// PostgreSQL for a query starting at byte offset within script into
+// a 1-based line and column of script.
+func lineColumn(script string, offset int, position int32) (line, column int) {
+	if position <= 0 || offset > len(script) {
+		return 0, 0
+	}
+
+	// Advance position-1 characters from offset.
+	i := offset
+	for n := int32(1); n < position && i < len(script); n++ {
+		_, size := utf8.DecodeRuneInString(script[i:])
+		i += size
+	}
+
+	line = 1 + strings.Count(script[:i], "\n")
+	lineStart := strings.LastIndexByte(script[:i], '\n') + 1
+	column = 1 + utf8.RuneCountInString(script[lineStart:i])
+	return line, column
+}
@@ -0,0 +1,327 @@
+package pgdbtemplatepgx
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// maskedValue replaces the values of masked columns.
+const maskedValue = "<masked>"
+
+// TableSnapshotOption configures SnapshotTables.
+type TableSnapshotOption func(*tableSnapshotConfig)
+
+// tableSnapshotConfig holds the options of a table snapshot.
+type tableSnapshotConfig struct {
+	maskedColumns map[string]struct{} // "column" or "table.column".
+	maskedTypes   map[string]struct{}
+	json          bool
+}
+
+// MaskColumns replaces non-NULL values of columns with "<masked>", e.g.
+// for generated timestamps. A column is given either by name, matching
+// that column in every table, or as "table.column".
+func MaskColumns(columns ...string) TableSnapshotOption {
+	return func(c *tableSnapshotConfig) {
+		for _, column := range columns {
+			c.maskedColumns[column] = struct{}{}
+		}
+	}
+}
+
+// MaskTypes replaces non-NULL values of all columns of the given types
+// with "<masked>". Types are named as by pgx, e.g. "timestamptz" or "uuid".
+func MaskTypes(types ...string) TableSnapshotOption {
+	return func(c *tableSnapshotConfig) {
+		for _, typeName := range types {
+			c.maskedTypes[typeName] = struct{}{}
+		}
+	}
+}
+
+// WithJSONSnapshot renders table snapshots as JSON instead of YAML.
+//
+// AssertTablesGolden uses JSON for golden files ending in ".json".
+func WithJSONSnapshot() TableSnapshotOption {
+	return func(c *tableSnapshotConfig) {
+		c.json = true
+	}
+}
+
+// SnapshotTables renders the contents of tables in the database behind
+// conn, which must be a *DatabaseConnection, as YAML.
+//
+// Tables are rendered in the given order, and their rows are sorted by
+// primary key, or by their rendered values for tables without one or
+// with a masked primary key column.
+// Columns keep their table order. Values are decoded by pgx and rendered
+// consistently: timestamps in UTC RFC 3339, UUIDs in canonical form,
+// numerics exactly and bytea as hex.
+func SnapshotTables(ctx context.Context, conn pgdbtemplate.DatabaseConnection, tables []string, opts ...TableSnapshotOption) ([]byte, error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok {
+		return nil, fmt.Errorf("SnapshotTables requires a *pgdbtemplatepgx.DatabaseConnection, got %T", conn)
+	}
+	config := &tableSnapshotConfig{
+		maskedColumns: make(map[string]struct{}),
+		maskedTypes:   make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	snapshot := &orderedMap{}
+	for _, table := range tables {
+		rows, err := snapshotTable(ctx, pgxConn, table, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot table %s: %w", table, err)
+		}
+		snapshot.set(table, rows)
+	}
+
+	if config.json {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(snapshot); err != nil {
+			return nil, fmt.Errorf("failed to encode table snapshot: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to encode table snapshot: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode table snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// snapshotTable returns the rendered rows of table.
+func snapshotTable(ctx context.Context, conn *DatabaseConnection, table string, config *tableSnapshotConfig) ([]*orderedMap, error) {
+	identifier := pgx.Identifier(strings.Split(table, "."))
+
+	var primaryKey []string
+	pkQuery := `
+		SELECT COALESCE(array_agg(a.attname ORDER BY array_position(i.indkey::int2[], a.attnum)), '{}')
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey::int2[])
+		WHERE i.indrelid = $1::text::regclass AND i.indisprimary
+	`
+	if err := conn.Pool.QueryRow(ctx, pkQuery, identifier.Sanitize()).Scan(&primaryKey); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT * FROM " + identifier.Sanitize()
+	if len(primaryKey) > 0 {
+		orderBy := make([]string, len(primaryKey))
+		for i, column := range primaryKey {
+			orderBy[i] = pgx.Identifier{column}.Sanitize()
+		}
+		query += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+
+	rows, err := conn.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	isPrimaryKey := make(map[string]bool, len(primaryKey))
+	for _, column := range primaryKey {
+		isPrimaryKey[column] = true
+	}
+
+	fields := rows.FieldDescriptions()
+	typeMap := rows.Conn().TypeMap()
+	masked := make([]bool, len(fields))
+	tableName := identifier[len(identifier)-1]
+	// Masked primary keys do not order rows as they are rendered.
+	sortRendered := len(primaryKey) == 0
+	for i, field := range fields {
+		_, byColumn := config.maskedColumns[field.Name]
+		_, byTable := config.maskedColumns[tableName+"."+field.Name]
+		_, byQualified := config.maskedColumns[table+"."+field.Name]
+		var byType bool
+		if dataType, ok := typeMap.TypeForOID(field.DataTypeOID); ok {
+			_, byType = config.maskedTypes[dataType.Name]
+		}
+		masked[i] = byColumn || byTable || byQualified || byType
+		if masked[i] && isPrimaryKey[field.Name] {
+			sortRendered = true
+		}
+	}
+
+	result := []*orderedMap{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		row := &orderedMap{}
+		for i, field := range fields {
+			value := renderValue(values[i])
+			if masked[i] && value != nil {
+				value = maskedValue
+			}
+			row.set(field.Name, value)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if sortRendered {
+		keys := make([]string, len(result))
+		for i, row := range result {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = string(data)
+		}
+		sort.Sort(rowsByKey{rows: result, keys: keys})
+	}
+	return result, nil
+}
+
+// rowsByKey sorts rows by their keys.
+type rowsByKey struct {
+	rows []*orderedMap
+	keys []string
+}
+
+func (r rowsByKey) Len() int           { return len(r.rows) }
+func (r rowsByKey) Less(i, j int) bool { return r.keys[i] < r.keys[j] }
+func (r rowsByKey) Swap(i, j int) {
+	r.rows[i], r.rows[j] = r.rows[j], r.rows[i]
+	r.keys[i], r.keys[j] = r.keys[j], r.keys[i]
+}
+
+// renderValue converts a value decoded by pgx into a value that renders
+// the same way in JSON and YAML.
+func renderValue(value any) any {
+	switch v := value.(type) {
+	case nil, string, bool, int64, float64:
+		return v
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case []byte:
+		return `\x` + hex.EncodeToString(v)
+	case *big.Int:
+		return v.String()
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = renderValue(elem)
+		}
+		return out
+	case map[string]any:
+		// Keys are sorted when encoding.
+		out := make(map[string]any, len(v))
+		for key, elem := range v {
+			out[key] = renderValue(elem)
+		}
+		return out
+	case driver.Valuer:
+		// pgtype values, e.g. pgtype.Numeric or pgtype.Interval,
+		// render through their text representation.
+		if dv, err := v.Value(); err == nil {
+			if s, ok := dv.(string); ok {
+				return s
+			}
+			return renderValue(dv)
+		}
+	case fmt.Stringer:
+		return v.String() // E.g. netip.Prefix.
+	}
+	return fmt.Sprint(value)
+}
+
+// orderedMap is a mapping that keeps the order of its keys when encoded
+// as JSON or YAML.
+type orderedMap struct {
+	keys   []string
+	values []any
+}
+
+func (m *orderedMap) set(key string, value any) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encodeJSON(&buf, key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := encodeJSON(&buf, m.values[i]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// encodeJSON writes value to buf as JSON without escaping HTML
+// characters, which keep values such as "<masked>" readable.
+func encodeJSON(buf *bytes.Buffer, value any) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // Encode appends a newline.
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (m *orderedMap) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i, key := range m.keys {
+		keyNode := &yaml.Node{}
+		if err := keyNode.Encode(key); err != nil {
+			return nil, err
+		}
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(m.values[i]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, keyNode, valueNode)
+	}
+	return node, nil
+}
@@ -0,0 +1,187 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+	"github.com/andrei-polukhin/pgdbtemplate-pgx/pgdbtemplatepgxtest"
+)
+
+// tableSnapshotSchema holds rows in an order that differs from
+// their primary key order.
+const tableSnapshotSchema = `
+	CREATE TABLE accounts (
+		id UUID PRIMARY KEY,
+		name TEXT NOT NULL,
+		balance NUMERIC(10, 2),
+		settings JSONB,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	INSERT INTO accounts (id, name, balance, settings) VALUES
+		('00000000-0000-0000-0000-000000000002', 'second', 10.50, '{"b": 1, "a": [true]}'),
+		('00000000-0000-0000-0000-000000000001', 'first', NULL, NULL);
+	CREATE TABLE labels (name TEXT, weight INT);
+	INSERT INTO labels VALUES ('z', 1), ('a', 2);
+`
+
+// assertTablesGolden runs AssertTablesGolden and returns the failures.
+func assertTablesGolden(t testing.TB, conn pgdbtemplate.DatabaseConnection, path string, tables []string, opts ...pgdbtemplatepgx.TableSnapshotOption) []string {
+	return recordFailures(t, func(tb testing.TB) {
+		pgdbtemplatepgxtest.AssertTablesGolden(tb, conn, path, tables, opts...)
+	})
+}
+
+func TestSnapshotTables(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	_, err := conn.ExecContext(ctx, tableSnapshotSchema)
+	c.Assert(err, qt.IsNil)
+
+	c.Run("YAML", func(c *qt.C) {
+		data, err := pgdbtemplatepgx.SnapshotTables(ctx, conn, []string{"accounts", "public.labels"},
+			pgdbtemplatepgx.MaskColumns("accounts.created_at"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, `accounts:
+  - id: 00000000-0000-0000-0000-000000000001
+    name: first
+    balance: null
+    settings: null
+    created_at: <masked>
+  - id: 00000000-0000-0000-0000-000000000002
+    name: second
+    balance: "10.50"
+    settings:
+      a:
+        - true
+      b: 1
+    created_at: <masked>
+public.labels:
+  - name: a
+    weight: 2
+  - name: z
+    weight: 1
+`)
+	})
+
+	c.Run("JSON with masked types", func(c *qt.C) {
+		data, err := pgdbtemplatepgx.SnapshotTables(ctx, conn, []string{"accounts"},
+			pgdbtemplatepgx.MaskTypes("timestamptz", "uuid"), pgdbtemplatepgx.WithJSONSnapshot())
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, `{
+  "accounts": [
+    {
+      "id": "<masked>",
+      "name": "first",
+      "balance": null,
+      "settings": null,
+      "created_at": "<masked>"
+    },
+    {
+      "id": "<masked>",
+      "name": "second",
+      "balance": "10.50",
+      "settings": {
+        "a": [
+          true
+        ],
+        "b": 1
+      },
+      "created_at": "<masked>"
+    }
+  ]
+}
+`)
+	})
+
+	c.Run("Masked random primary key", func(c *qt.C) {
+		_, err := conn.ExecContext(ctx, `
+			CREATE TABLE tokens (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), name TEXT);
+			INSERT INTO tokens (name) VALUES ('e'), ('d'), ('c'), ('b'), ('a');
+		`)
+		c.Assert(err, qt.IsNil)
+
+		data, err := pgdbtemplatepgx.SnapshotTables(ctx, conn, []string{"tokens"},
+			pgdbtemplatepgx.MaskTypes("uuid"))
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(data), qt.Equals, `tokens:
+  - id: <masked>
+    name: a
+  - id: <masked>
+    name: b
+  - id: <masked>
+    name: c
+  - id: <masked>
+    name: d
+  - id: <masked>
+    name: e
+`)
+	})
+
+	c.Run("Missing table", func(c *qt.C) {
+		_, err := pgdbtemplatepgx.SnapshotTables(ctx, conn, []string{"missing"})
+		c.Assert(err, qt.ErrorMatches, `failed to snapshot table missing: .*does not exist.*`)
+	})
+}
+
+// Not parallel, since it sets whether golden files are updated.
+func TestAssertTablesGolden(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+	conn, _ := createTestDatabase(c, provider)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	_, err := conn.ExecContext(ctx, tableSnapshotSchema)
+	c.Assert(err, qt.IsNil)
+
+	tables := []string{"labels"}
+	path := filepath.Join(c.TempDir(), "testdata", "labels.yaml")
+
+	failures := assertTablesGolden(t, conn, path, tables)
+	c.Assert(failures, qt.DeepEquals, []string{
+		fmt.Sprintf("golden file %s does not exist; run the test with -update to create it", path),
+	})
+
+	updateGolden(c, func() {
+		c.Assert(assertTablesGolden(t, conn, path, tables), qt.HasLen, 0)
+	})
+
+	data, err := os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Contains, "labels:\n")
+
+	// The unchanged contents match.
+	c.Assert(assertTablesGolden(t, conn, path, tables), qt.HasLen, 0)
+
+	// A changed row is reported with a line diff.
+	_, err = conn.ExecContext(ctx, "UPDATE labels SET weight = 3 WHERE name = 'z'")
+	c.Assert(err, qt.IsNil)
+	failures = assertTablesGolden(t, conn, path, tables)
+	c.Assert(failures, qt.DeepEquals, []string{
+		fmt.Sprintf("tables do not match golden file %s (run the test with -update to accept):\n"+
+			"  labels:\n"+
+			"    - name: a\n"+
+			"      weight: 2\n"+
+			"    - name: z\n"+
+			"-     weight: 1\n"+
+			"+     weight: 3\n", path),
+	})
+}
@@ -0,0 +1,456 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+)
+
+// globalTemplateBuildCounter is a global atomic counter for unique
+// template build database names.
+var globalTemplateBuildCounter int64
+
+// CoordinatorOption configures TemplateCoordinator.
+type CoordinatorOption func(*TemplateCoordinator)
+
+// WithMigrationFingerprint makes the coordinator reuse an existing template
+// only if it was built from the same migrations.
+//
+// The fingerprint computed by MigrationFingerprint for paths and
+// orderingFunc is stored as the comment of the template database.
+// A template with a matching fingerprint is reused even across test runs,
+// skipping migrations entirely. Otherwise the template is rebuilt under
+// a temporary name and renamed into place once complete, so that other
+// processes never observe a partially built template.
+func WithMigrationFingerprint(paths []string, orderingFunc func([]string) []string) CoordinatorOption {
+	return func(c *TemplateCoordinator) {
+		c.fingerprintFiles = func() ([]migrationFile, error) {
+			return readMigrationFiles(paths, orderingFunc)
+		}
+	}
+}
+
+// WithFSMigrationFingerprint is like WithMigrationFingerprint,
+// but reads the migration files from fsys.
+func WithFSMigrationFingerprint(fsys fs.FS, paths []string, orderingFunc func([]string) []string) CoordinatorOption {
+	return func(c *TemplateCoordinator) {
+		c.fingerprintFiles = func() ([]migrationFile, error) {
+			return fsMigrationSource(fsys).readFiles(paths, orderingFunc)
+		}
+	}
+}
+
+// TemplateCoordinator lets several processes share one template database.
+//
+// `go test ./...` runs every package in its own process. With a fixed
+// template name each of them would race to build the same template.
+// The coordinator serializes builds with a PostgreSQL advisory lock keyed
+// by the template name, held on a dedicated admin connection: the first
+// process builds the template, the others wait and reuse it.
+//
+// While initialized, the coordinator also holds a shared advisory lock
+// marking the template as in use, so that Cleanup only drops the template
+// in the last process using it.
+type TemplateCoordinator struct {
+	provider     *ConnectionProvider
+	config       pgdbtemplate.Config
+	tm           *pgdbtemplate.TemplateManager
+	templateName string
+	adminDBName  string
+
+	fingerprintFiles func() ([]migrationFile, error)
+
+	buildLockKey int64
+	useLockKey   int64
+
+	mu   sync.Mutex
+	conn *pgx.Conn // Dedicated admin connection holding the advisory locks.
+
+	testDBs sync.Map // Tracks test databases created through tm.
+}
+
+// trackingProvider records the test databases the coordinated template
+// manager connects to, since it does not expose the ones it tracks.
+type trackingProvider struct {
+	*ConnectionProvider
+	coordinator *TemplateCoordinator
+}
+
+// Connect implements pgdbtemplate.ConnectionProvider.
+func (p trackingProvider) Connect(ctx context.Context, databaseName string) (pgdbtemplate.DatabaseConnection, error) {
+	conn, err := p.ConnectionProvider.Connect(ctx, databaseName)
+	if err == nil && databaseName != p.coordinator.adminDBName && databaseName != p.coordinator.templateName {
+		p.coordinator.testDBs.Store(databaseName, true)
+	}
+	return conn, err
+}
+
+// NewTemplateCoordinator creates a template manager for config and
+// a coordinator guarding its initialization.
+//
+// config.ConnectionProvider must be a *ConnectionProvider and
+// config.TemplateName must be set, since processes can only share
+// a template under a name they agree on.
+func NewTemplateCoordinator(config pgdbtemplate.Config, opts ...CoordinatorOption) (*TemplateCoordinator, error) {
+	provider, ok := config.ConnectionProvider.(*ConnectionProvider)
+	if !ok {
+		return nil, fmt.Errorf("ConnectionProvider must be a *pgdbtemplatepgx.ConnectionProvider")
+	}
+	if config.TemplateName == "" {
+		return nil, fmt.Errorf("TemplateName is required")
+	}
+
+	adminDBName := config.AdminDBName
+	if adminDBName == "" {
+		adminDBName = defaultAdminDBName
+	}
+
+	coordinator := &TemplateCoordinator{
+		provider:     provider,
+		config:       config,
+		templateName: config.TemplateName,
+		adminDBName:  adminDBName,
+		buildLockKey: advisoryLockKey("pgdbtemplate:build:" + config.TemplateName),
+		useLockKey:   advisoryLockKey("pgdbtemplate:use:" + config.TemplateName),
+	}
+
+	tmConfig := config
+	tmConfig.ConnectionProvider = trackingProvider{ConnectionProvider: provider, coordinator: coordinator}
+	tm, err := pgdbtemplate.NewTemplateManager(tmConfig)
+	if err != nil {
+		return nil, err
+	}
+	coordinator.tm = tm
+
+	for _, opt := range opts {
+		opt(coordinator)
+	}
+	return coordinator, nil
+}
+
+// TemplateManager returns the coordinated template manager.
+//
+// Use it for CreateTestDatabase and DropTestDatabase; call Initialize
+// and Cleanup on the coordinator instead.
+func (c *TemplateCoordinator) TemplateManager() *pgdbtemplate.TemplateManager {
+	return c.tm
+}
+
+// Initialize builds the template database unless another process already
+// did, waiting for a concurrent build to finish.
+//
+// A template left behind by an interrupted build, i.e. one that was never
+// marked as a template, is dropped and rebuilt.
+func (c *TemplateCoordinator) Initialize(ctx context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		return nil
+	}
+
+	conn, err := c.connectAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	// Closing the session releases all advisory locks it holds.
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, conn.Close(ctx))
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", c.buildLockKey); err != nil {
+		return fmt.Errorf("failed to acquire template build lock: %w", err)
+	}
+
+	if c.fingerprintFiles != nil {
+		if err := c.ensureFingerprintedTemplate(ctx, conn); err != nil {
+			return err
+		}
+	} else if err := c.dropInterruptedBuild(ctx, conn); err != nil {
+		return err
+	}
+	if err := c.tm.Initialize(ctx); err != nil {
+		return err
+	}
+
+	// Mark the template as in use before letting other processes in.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock_shared($1)", c.useLockKey); err != nil {
+		return fmt.Errorf("failed to acquire template use lock: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", c.buildLockKey); err != nil {
+		return fmt.Errorf("failed to release template build lock: %w", err)
+	}
+
+	c.conn = conn
+	return nil
+}
+
+// Cleanup releases this process's use of the template and drops the test
+// databases created through its template manager.
+//
+// The template itself is only dropped if no other process is using it.
+//
+// With WithMigrationFingerprint the template is always kept for reuse by
+// later runs.
+func (c *TemplateCoordinator) Cleanup(ctx context.Context) (errs error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	conn := c.conn
+	c.conn = nil
+	defer func() { errs = errors.Join(errs, conn.Close(ctx)) }()
+
+	// Keep other processes from starting to use the template
+	// while deciding whether to drop it.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", c.buildLockKey); err != nil {
+		return fmt.Errorf("failed to acquire template build lock: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock_shared($1)", c.useLockKey); err != nil {
+		return fmt.Errorf("failed to release template use lock: %w", err)
+	}
+	if c.fingerprintFiles != nil {
+		// Keep the template for later runs.
+		return c.dropTestDatabases(ctx, conn)
+	}
+
+	var last bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", c.useLockKey).Scan(&last); err != nil {
+		return fmt.Errorf("failed to check template use lock: %w", err)
+	}
+	if !last {
+		// Other processes still use the template.
+		return c.dropTestDatabases(ctx, conn)
+	}
+
+	if err := c.tm.Cleanup(ctx); err != nil {
+		return err
+	}
+	c.testDBs.Range(func(key, _ any) bool {
+		c.testDBs.Delete(key)
+		return true
+	})
+	return nil
+}
+
+// dropTestDatabases drops the test databases created through the template
+// manager that still exist, leaving the template in place.
+func (c *TemplateCoordinator) dropTestDatabases(ctx context.Context, conn *pgx.Conn) (errs error) {
+	var dbNames []string
+	c.testDBs.Range(func(key, _ any) bool {
+		dbNames = append(dbNames, key.(string))
+		return true
+	})
+	if len(dbNames) == 0 {
+		return nil
+	}
+
+	// Skip the databases already dropped with DropTestDatabase.
+	rows, err := conn.Query(ctx, "SELECT datname FROM pg_database WHERE datname = ANY($1)", dbNames)
+	if err != nil {
+		return fmt.Errorf("failed to list test databases: %w", err)
+	}
+	existing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to list test databases: %w", err)
+	}
+	for _, dbName := range dbNames {
+		c.testDBs.Delete(dbName)
+	}
+
+	// Drop them through the template manager, so that it stops tracking them.
+	for _, dbName := range existing {
+		if err := c.tm.DropTestDatabase(ctx, dbName); err != nil {
+			errs = errors.Join(errs, err)
+			c.testDBs.Store(dbName, true)
+		}
+	}
+	return errs
+}
+
+// connectAdmin opens the dedicated admin connection.
+func (c *TemplateCoordinator) connectAdmin(ctx context.Context) (*pgx.Conn, error) {
+	connConfig, err := pgx.ParseConfig(c.provider.connectionStringFunc(c.adminDBName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	return conn, nil
+}
+
+// dropInterruptedBuild drops the template database if it exists but was
+// never marked as a template.
+//
+// The build lock must be held, so no live process can be building it.
+func (c *TemplateCoordinator) dropInterruptedBuild(ctx context.Context, conn *pgx.Conn) error {
+	var isTemplate bool
+	err := conn.QueryRow(ctx,
+		"SELECT datistemplate FROM pg_database WHERE datname = $1", c.templateName,
+	).Scan(&isTemplate)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && isTemplate) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check template database: %w", err)
+	}
+
+	dropQuery := fmt.Sprintf("DROP DATABASE %s", pgx.Identifier{c.templateName}.Sanitize())
+	if _, err := conn.Exec(ctx, dropQuery); err != nil {
+		return fmt.Errorf("failed to drop interrupted template build: %w", err)
+	}
+	return nil
+}
+
+// ensureFingerprintedTemplate makes sure the template database exists and
+// carries the fingerprint of the current migrations, rebuilding it if not.
+//
+// The build lock must be held.
+func (c *TemplateCoordinator) ensureFingerprintedTemplate(ctx context.Context, conn *pgx.Conn) (err error) {
+	fingerprint, err := c.currentFingerprint(ctx)
+	if err != nil {
+		return err
+	}
+	comment := fingerprintCommentPrefix + fingerprint
+
+	var (
+		isTemplate      bool
+		existingComment string
+	)
+	err = conn.QueryRow(ctx, `
+		SELECT datistemplate, COALESCE(shobj_description(oid, 'pg_database'), '')
+		FROM pg_database
+		WHERE datname = $1
+	`, c.templateName).Scan(&isTemplate, &existingComment)
+	exists := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to check template database: %w", err)
+	}
+	if exists && isTemplate && existingComment == comment {
+		return nil // Up-to-date template, reuse it.
+	}
+
+	if exists {
+		// Replacing a template other processes use would pull it from under them.
+		var unused bool
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", c.useLockKey).Scan(&unused); err != nil {
+			return fmt.Errorf("failed to check template use lock: %w", err)
+		}
+		if !unused {
+			return fmt.Errorf("template database %q is outdated but still in use by other processes", c.templateName)
+		}
+		defer func() {
+			if _, unlockErr := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", c.useLockKey); unlockErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to release template use lock: %w", unlockErr))
+			}
+		}()
+	}
+
+	// Build the new template under a temporary name.
+	buildName := fmt.Sprintf("template_build_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalTemplateBuildCounter, 1))
+	buildConfig := c.config
+	buildConfig.TemplateName = buildName
+	buildTM, err := pgdbtemplate.NewTemplateManager(buildConfig)
+	if err != nil {
+		return err
+	}
+	if err := buildTM.Initialize(ctx); err != nil {
+		return fmt.Errorf("failed to build template database: %w", err)
+	}
+
+	// Drop the build if it cannot be moved into place.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cleanupErr := buildTM.Cleanup(ctx); cleanupErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to drop template build: %w", cleanupErr))
+		}
+	}()
+
+	commentQuery := fmt.Sprintf("COMMENT ON DATABASE %s IS '%s'", pgx.Identifier{buildName}.Sanitize(), comment)
+	if _, err := conn.Exec(ctx, commentQuery); err != nil {
+		return fmt.Errorf("failed to store template fingerprint: %w", err)
+	}
+
+	if exists {
+		if err := c.dropTemplate(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	renameQuery := fmt.Sprintf("ALTER DATABASE %s RENAME TO %s",
+		pgx.Identifier{buildName}.Sanitize(), pgx.Identifier{c.templateName}.Sanitize())
+	if _, err := conn.Exec(ctx, renameQuery); err != nil {
+		return fmt.Errorf("failed to move template build into place: %w", err)
+	}
+	return nil
+}
+
+// currentFingerprint computes the fingerprint of the current migrations.
+func (c *TemplateCoordinator) currentFingerprint(ctx context.Context) (string, error) {
+	files, err := c.fingerprintFiles()
+	if err != nil {
+		return "", fmt.Errorf("failed to read migrations for fingerprint: %w", err)
+	}
+
+	adminConn, err := c.provider.Connect(ctx, c.adminDBName)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to admin database: %w", err)
+	}
+	defer adminConn.Close()
+
+	fingerprint, err := fingerprintMigrationFiles(ctx, adminConn, files)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute migration fingerprint: %w", err)
+	}
+	return fingerprint, nil
+}
+
+// dropTemplate drops the existing template database.
+//
+// The caller must hold the use lock exclusively, so any remaining
+// connections to the template are stale.
+func (c *TemplateCoordinator) dropTemplate(ctx context.Context, conn *pgx.Conn) error {
+	unmarkQuery := fmt.Sprintf("ALTER DATABASE %s WITH is_template FALSE", pgx.Identifier{c.templateName}.Sanitize())
+	if _, err := conn.Exec(ctx, unmarkQuery); err != nil {
+		return fmt.Errorf("failed to unmark outdated template database: %w", err)
+	}
+
+	terminateQuery := `
+		SELECT pg_terminate_backend(pid)
+		FROM pg_stat_activity
+		WHERE datname = $1 AND pid <> pg_backend_pid()
+	`
+	if _, err := conn.Exec(ctx, terminateQuery, c.templateName); err != nil {
+		return fmt.Errorf("failed to terminate connections to outdated template database: %w", err)
+	}
+
+	dropQuery := fmt.Sprintf("DROP DATABASE %s", pgx.Identifier{c.templateName}.Sanitize())
+	if _, err := conn.Exec(ctx, dropQuery); err != nil {
+		return fmt.Errorf("failed to drop outdated template database: %w", err)
+	}
+	return nil
+}
+
+// advisoryLockKey derives a 64-bit advisory lock key from name.
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
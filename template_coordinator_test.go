@@ -0,0 +1,198 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// countingMigrationRunner counts how many times migrations were run.
+type countingMigrationRunner struct {
+	runner pgdbtemplate.MigrationRunner
+	runs   atomic.Int32
+}
+
+func (r *countingMigrationRunner) RunMigrations(ctx context.Context, conn pgdbtemplate.DatabaseConnection) error {
+	r.runs.Add(1)
+	return r.runner.RunMigrations(ctx, conn)
+}
+
+// databaseExists reports whether a database named dbName exists.
+func databaseExists(c *qt.C, dbName string) bool {
+	ctx := context.Background()
+	provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+	defer provider.Close()
+
+	conn, err := provider.Connect(ctx, "postgres")
+	c.Assert(err, qt.IsNil)
+	defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+	var exists bool
+	err = conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", dbName,
+	).Scan(&exists)
+	c.Assert(err, qt.IsNil)
+	return exists
+}
+
+func TestTemplateCoordinator(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Required fields", func(c *qt.C) {
+		_, err := pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+			ConnectionProvider: pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx),
+			MigrationRunner:    &pgdbtemplate.NoOpMigrationRunner{},
+		})
+		c.Assert(err, qt.ErrorMatches, "TemplateName is required")
+
+		_, err = pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+			MigrationRunner: &pgdbtemplate.NoOpMigrationRunner{},
+			TemplateName:    "pgx_coordinated_template",
+		})
+		c.Assert(err, qt.ErrorMatches, "ConnectionProvider must be a .*")
+	})
+
+	c.Run("Concurrent processes build the template once", func(c *qt.C) {
+		c.Parallel()
+		templateName := fmt.Sprintf("pgx_coordinated_%d_%d", time.Now().UnixNano(), os.Getpid())
+		runner := &countingMigrationRunner{runner: createTestMigrationRunner(c)}
+
+		// Every coordinator stands in for a separate test process.
+		const processes = 4
+		coordinators := make([]*pgdbtemplatepgx.TemplateCoordinator, processes)
+		for i := range coordinators {
+			provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+			defer provider.Close()
+
+			coordinator, err := pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    runner,
+				TemplateName:       templateName,
+				TestDBPrefix:       fmt.Sprintf("pgx_coordinated_%d_%d_%d_", time.Now().UnixNano(), os.Getpid(), i),
+			})
+			c.Assert(err, qt.IsNil)
+			coordinators[i] = coordinator
+		}
+
+		var wg sync.WaitGroup
+		for _, coordinator := range coordinators {
+			wg.Add(1)
+			go func(coordinator *pgdbtemplatepgx.TemplateCoordinator) {
+				defer wg.Done()
+				c.Check(coordinator.Initialize(ctx), qt.IsNil)
+			}(coordinator)
+		}
+		wg.Wait()
+		c.Assert(runner.runs.Load(), qt.Equals, int32(1))
+
+		// Every process can create test databases from the shared template.
+		for _, coordinator := range coordinators {
+			tm := coordinator.TemplateManager()
+			conn, dbName, err := tm.CreateTestDatabase(ctx)
+			c.Assert(err, qt.IsNil)
+
+			var count int
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+			c.Assert(err, qt.IsNil)
+			c.Assert(count, qt.Equals, 2)
+
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+		}
+
+		// Only the last process to clean up drops the template.
+		for i, coordinator := range coordinators {
+			c.Assert(coordinator.Cleanup(ctx), qt.IsNil)
+			c.Assert(databaseExists(c, templateName), qt.Equals, i < processes-1)
+		}
+	})
+
+	c.Run("Cleanup drops own test databases while template is shared", func(c *qt.C) {
+		c.Parallel()
+		templateName := fmt.Sprintf("pgx_coordinated_shared_%d_%d", time.Now().UnixNano(), os.Getpid())
+		runner := createTestMigrationRunner(c)
+
+		coordinators := make([]*pgdbtemplatepgx.TemplateCoordinator, 2)
+		for i := range coordinators {
+			provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+			defer provider.Close()
+
+			coordinator, err := pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+				ConnectionProvider: provider,
+				MigrationRunner:    runner,
+				TemplateName:       templateName,
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(coordinator.Initialize(ctx), qt.IsNil)
+			coordinators[i] = coordinator
+		}
+
+		tm := coordinators[0].TemplateManager()
+		kept, keptName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(kept.Close(), qt.IsNil)
+		dropped, droppedName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		c.Assert(dropped.Close(), qt.IsNil)
+		c.Assert(tm.DropTestDatabase(ctx, droppedName), qt.IsNil)
+
+		c.Assert(coordinators[0].Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(c, keptName), qt.IsFalse)
+		c.Assert(databaseExists(c, templateName), qt.IsTrue)
+
+		c.Assert(coordinators[1].Cleanup(ctx), qt.IsNil)
+		c.Assert(databaseExists(c, templateName), qt.IsFalse)
+	})
+
+	c.Run("Interrupted build is rebuilt", func(c *qt.C) {
+		c.Parallel()
+		templateName := fmt.Sprintf("pgx_coordinated_broken_%d_%d", time.Now().UnixNano(), os.Getpid())
+
+		// Simulate a process that died after CREATE DATABASE,
+		// before migrations were applied and the template was marked.
+		adminConn, err := pgx.Connect(ctx, testConnectionStringFuncPgx("postgres"))
+		c.Assert(err, qt.IsNil)
+		_, err = adminConn.Exec(ctx, fmt.Sprintf("CREATE DATABASE %s", pgx.Identifier{templateName}.Sanitize()))
+		c.Assert(err, qt.IsNil)
+		c.Assert(adminConn.Close(ctx), qt.IsNil)
+
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer provider.Close()
+
+		runner := &countingMigrationRunner{runner: createTestMigrationRunner(c)}
+		coordinator, err := pgdbtemplatepgx.NewTemplateCoordinator(pgdbtemplate.Config{
+			ConnectionProvider: provider,
+			MigrationRunner:    runner,
+			TemplateName:       templateName,
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(coordinator.Initialize(ctx), qt.IsNil)
+		defer func() { c.Assert(coordinator.Cleanup(ctx), qt.IsNil) }()
+		c.Assert(runner.runs.Load(), qt.Equals, int32(1))
+
+		tm := coordinator.TemplateManager()
+		conn, dbName, err := tm.CreateTestDatabase(ctx)
+		c.Assert(err, qt.IsNil)
+		defer func() {
+			c.Assert(conn.Close(), qt.IsNil)
+			c.Assert(tm.DropTestDatabase(ctx, dbName), qt.IsNil)
+		}()
+
+		var count int
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count)
+		c.Assert(err, qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+	})
+}
@@ -0,0 +1,228 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// globalTestRoleCounter is a global atomic counter for unique test
+// role names across all providers.
+var globalTestRoleCounter int64
+
+// TestRole is a role created for a single test by CreateTestRole.
+type TestRole struct {
+	name string
+	ref  *DatabaseConnection
+}
+
+// Name returns the name of the role.
+func (r *TestRole) Name() string {
+	return r.name
+}
+
+// CreateTestRole creates a role for testing row-level security policies
+// of the database behind conn, which must have been created by this
+// provider.
+//
+// The role cannot log in and is granted membership in templateRole, if
+// not empty, rather than copies of its privileges. Being created with
+// INHERIT, the role holds whatever privileges templateRole holds at any
+// time, including those granted after CreateTestRole, and is subject to
+// the policies for templateRole, which copied privileges would not make
+// it. Attributes of templateRole, such as BYPASSRLS, are not inherited.
+// Statements run as the role through connections returned by Acquire:
+//
+//	tenantA, err := provider.CreateTestRole(ctx, testDB, "app_user")
+//	...
+//	roleConn, err := tenantA.Acquire(ctx, map[string]string{"app.tenant_id": "a"})
+//	...
+//	defer roleConn.Release()
+//
+// Roles are cluster-wide. They are dropped by DropTestRole or Shutdown,
+// together with any objects and privileges they own in the database.
+// Unless the session user of the provider is a superuser, it needs to be
+// a member of the role to switch to it, and stays one until the role is
+// dropped.
+// The role keeps the pool of conn open until it is dropped, so test roles
+// should be dropped before their database.
+func (p *ConnectionProvider) CreateTestRole(ctx context.Context, conn pgdbtemplate.DatabaseConnection, templateRole string) (_ *TestRole, err error) {
+	pgxConn, ok := conn.(*DatabaseConnection)
+	if !ok || pgxConn.provider != p {
+		return nil, fmt.Errorf("connection was not created by this provider")
+	}
+
+	ref, err := p.connect(ctx, pgxConn.key())
+	if err != nil {
+		return nil, err
+	}
+	role := &TestRole{
+		name: fmt.Sprintf("test_role_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&globalTestRoleCounter, 1)),
+		ref:  ref,
+	}
+
+	roleIdent := pgx.Identifier{role.name}.Sanitize()
+	if _, err := ref.Pool.Exec(ctx, "CREATE ROLE "+roleIdent+" NOLOGIN INHERIT"); err != nil {
+		_ = ref.Close()
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	// Drop the role if granting privileges to it fails.
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, p.dropTestRole(ctx, role), ref.Close())
+		}
+	}()
+
+	// Superusers may SET ROLE to any role. Other session users are
+	// granted the role to be able to, as they need not be members.
+	var superuser bool
+	if err := ref.Pool.QueryRow(ctx, "SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&superuser); err != nil {
+		return nil, fmt.Errorf("failed to look up the current user: %w", err)
+	}
+	if !superuser {
+		if _, err := ref.Pool.Exec(ctx, "GRANT "+roleIdent+" TO CURRENT_USER"); err != nil {
+			return nil, fmt.Errorf("failed to grant role %q to the current user: %w", role.name, err)
+		}
+	}
+	if templateRole != "" {
+		query := fmt.Sprintf("GRANT %s TO %s", pgx.Identifier{templateRole}.Sanitize(), roleIdent)
+		if _, err := ref.Pool.Exec(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to grant role %q to %q: %w", templateRole, role.name, err)
+		}
+	}
+
+	p.testRolesMu.Lock()
+	p.testRoles[role.name] = role
+	p.testRolesMu.Unlock()
+	return role, nil
+}
+
+// RoleConn is a connection acquired by TestRole.Acquire whose statements
+// run as the test role.
+type RoleConn struct {
+	*pgxpool.Conn
+	reset string
+}
+
+// Acquire acquires a connection from the pool of the role's database and
+// switches it to the role with SET ROLE. settings, e.g. "app.tenant_id",
+// are set for the session with SET as well.
+//
+// The role and settings apply until Release, which restores them before
+// returning the connection to the pool. Statements such as RESET ROLE run
+// through the connection override them until then.
+func (r *TestRole) Acquire(ctx context.Context, settings map[string]string) (*RoleConn, error) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	set := []string{"SET ROLE " + pgx.Identifier{r.name}.Sanitize()}
+	reset := []string{"RESET ROLE"}
+	for _, name := range names {
+		ident := pgx.Identifier(strings.Split(name, ".")).Sanitize()
+		set = append(set, "SET "+ident+" = '"+strings.ReplaceAll(settings[name], "'", "''")+"'")
+		reset = append(reset, "RESET "+ident)
+	}
+
+	poolConn, err := r.ref.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if _, err := execUntraced(ctx, poolConn.Conn(), strings.Join(set, "; ")); err != nil {
+		_ = poolConn.Conn().Close(ctx)
+		poolConn.Release()
+		return nil, fmt.Errorf("failed to switch to role %q: %w", r.name, err)
+	}
+	return &RoleConn{Conn: poolConn, reset: strings.Join(reset, "; ")}, nil
+}
+
+// Release restores the session's role and settings and returns the
+// connection to the pool. The connection is closed instead if they
+// cannot be restored.
+func (c *RoleConn) Release() {
+	ctx := context.Background()
+	if _, err := execUntraced(ctx, c.Conn.Conn(), c.reset); err != nil {
+		_ = c.Conn.Conn().Close(ctx)
+	}
+	c.Conn.Release()
+}
+
+// DropTestRole drops a role created by CreateTestRole, together with the
+// objects and privileges it owns in its database.
+//
+// The role cannot be dropped while it owns objects or holds privileges in
+// other databases, e.g. in forks or checkpoints of its database created
+// after granting them.
+func (p *ConnectionProvider) DropTestRole(ctx context.Context, roleName string) error {
+	p.testRolesMu.Lock()
+	role, exists := p.testRoles[roleName]
+	p.testRolesMu.Unlock()
+	if !exists {
+		return fmt.Errorf("unknown test role %q", roleName)
+	}
+
+	if err := p.dropTestRole(ctx, role); err != nil {
+		return err
+	}
+
+	p.testRolesMu.Lock()
+	delete(p.testRoles, roleName)
+	p.testRolesMu.Unlock()
+	return nil
+}
+
+// dropTestRole drops role and releases its reference to the pool of its
+// database. If the database no longer exists, the role is dropped through
+// the admin database.
+func (p *ConnectionProvider) dropTestRole(ctx context.Context, role *TestRole) error {
+	roleIdent := pgx.Identifier{role.name}.Sanitize()
+	query := "DROP OWNED BY " + roleIdent + "; DROP ROLE " + roleIdent
+
+	// The reference may point to a pool closed by Close,
+	// so the database is connected anew.
+	conn, err := p.connect(ctx, role.ref.key())
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "3D000" { // invalid_catalog_name
+		conn, err = p.connect(ctx, poolKey{database: p.adminDBName})
+		query = "DROP ROLE " + roleIdent
+	}
+	if err != nil {
+		return fmt.Errorf("failed to drop role %q: %w", role.name, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Pool.Exec(ctx, query, pgx.QueryExecModeSimpleProtocol); err != nil {
+		return fmt.Errorf("failed to drop role %q: %w", role.name, err)
+	}
+	return role.ref.Close()
+}
+
+// dropTestRoles drops all test roles tracked by the provider.
+func (p *ConnectionProvider) dropTestRoles(ctx context.Context) (errs error) {
+	p.testRolesMu.Lock()
+	roleNames := make([]string, 0, len(p.testRoles))
+	for roleName := range p.testRoles {
+		roleNames = append(roleNames, roleName)
+	}
+	p.testRolesMu.Unlock()
+
+	for _, roleName := range roleNames {
+		if err := p.DropTestRole(ctx, roleName); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
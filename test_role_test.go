@@ -0,0 +1,222 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+)
+
+// roleExists reports whether the role exists in the cluster of conn.
+func roleExists(c *qt.C, conn pgdbtemplate.DatabaseConnection, roleName string) bool {
+	var exists bool
+	err := conn.QueryRowContext(context.Background(),
+		"SELECT EXISTS (SELECT FROM pg_roles WHERE rolname = $1)", roleName).Scan(&exists)
+	c.Assert(err, qt.IsNil)
+	return exists
+}
+
+// createTenantTable creates a table with a row-level security policy for
+// a new application role in the database of conn, and returns the role.
+func createTenantTable(c *qt.C, conn pgdbtemplate.DatabaseConnection) string {
+	ctx := context.Background()
+	appRole := fmt.Sprintf("app_user_%d_%d", time.Now().UnixNano(), os.Getpid())
+	ident := pgx.Identifier{appRole}.Sanitize()
+
+	for _, query := range []string{
+		"CREATE ROLE " + ident + " NOLOGIN",
+		"CREATE TABLE documents (tenant_id TEXT NOT NULL, title TEXT NOT NULL)",
+		"INSERT INTO documents VALUES ('a', 'A1'), ('a', 'A2'), ('b', 'B1')",
+		"ALTER TABLE documents ENABLE ROW LEVEL SECURITY",
+		"CREATE POLICY tenant_isolation ON documents TO " + ident +
+			" USING (tenant_id = current_setting('app.tenant_id', true))",
+		"GRANT SELECT ON documents TO " + ident,
+	} {
+		_, err := conn.ExecContext(ctx, query)
+		c.Assert(err, qt.IsNil)
+	}
+	return appRole
+}
+
+// dropAppRole drops a role created by createTenantTable.
+func dropAppRole(c *qt.C, conn pgdbtemplate.DatabaseConnection, appRole string) {
+	ident := pgx.Identifier{appRole}.Sanitize()
+	_, err := conn.ExecContext(context.Background(), "DROP OWNED BY "+ident)
+	c.Assert(err, qt.IsNil)
+	_, err = conn.ExecContext(context.Background(), "DROP ROLE "+ident)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestTestRole(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Tenants see their own rows", func(c *qt.C) {
+		c.Parallel()
+		// A single connection shows that the role and settings are reset.
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithMaxConns(1))
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		appRole := createTenantTable(c, conn)
+		defer dropAppRole(c, conn, appRole)
+
+		var sessionUser string
+		c.Assert(conn.QueryRowContext(ctx, "SELECT current_user").Scan(&sessionUser), qt.IsNil)
+
+		for tenant, want := range map[string][]string{"a": {"A1", "A2"}, "b": {"B1"}} {
+			role, err := provider.CreateTestRole(ctx, conn, appRole)
+			c.Assert(err, qt.IsNil)
+
+			roleConn, err := role.Acquire(ctx, map[string]string{"app.tenant_id": tenant})
+			c.Assert(err, qt.IsNil)
+
+			var currentUser string
+			c.Assert(roleConn.QueryRow(ctx, "SELECT current_user").Scan(&currentUser), qt.IsNil)
+			c.Assert(currentUser, qt.Equals, role.Name())
+
+			rows, err := roleConn.Query(ctx, "SELECT title FROM documents ORDER BY title")
+			c.Assert(err, qt.IsNil)
+			titles, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			c.Assert(err, qt.IsNil)
+			c.Assert(titles, qt.DeepEquals, want)
+			roleConn.Release()
+
+			var currentUserAfter, tenantAfter string
+			err = conn.QueryRowContext(ctx, "SELECT current_user, current_setting('app.tenant_id', true)").
+				Scan(&currentUserAfter, &tenantAfter)
+			c.Assert(err, qt.IsNil)
+			c.Assert(currentUserAfter, qt.Equals, sessionUser)
+			c.Assert(tenantAfter, qt.Equals, "")
+
+			c.Assert(provider.DropTestRole(ctx, role.Name()), qt.IsNil)
+			c.Assert(roleExists(c, conn, role.Name()), qt.IsFalse)
+		}
+	})
+
+	c.Run("Role inherits later privileges of its template", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+		appRole := createTenantTable(c, conn)
+		defer dropAppRole(c, conn, appRole)
+
+		role, err := provider.CreateTestRole(ctx, conn, appRole)
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(provider.DropTestRole(ctx, role.Name()), qt.IsNil) }()
+
+		// Superusers switch roles without being granted them.
+		var superuser, member bool
+		err = conn.QueryRowContext(ctx, `
+			SELECT u.rolsuper, EXISTS (
+				SELECT FROM pg_auth_members m JOIN pg_roles r ON r.oid = m.roleid
+				WHERE r.rolname = $1 AND m.member = u.oid
+			)
+			FROM pg_roles u WHERE u.rolname = current_user`, role.Name()).Scan(&superuser, &member)
+		c.Assert(err, qt.IsNil)
+		c.Assert(member, qt.Equals, !superuser)
+
+		_, err = conn.ExecContext(ctx, "GRANT SELECT ON test_table TO "+pgx.Identifier{appRole}.Sanitize())
+		c.Assert(err, qt.IsNil)
+
+		roleConn, err := role.Acquire(ctx, nil)
+		c.Assert(err, qt.IsNil)
+		defer roleConn.Release()
+
+		var count int
+		c.Assert(roleConn.QueryRow(ctx, "SELECT COUNT(*) FROM test_table").Scan(&count), qt.IsNil)
+		c.Assert(count, qt.Equals, 2)
+	})
+
+	c.Run("Role without template has no privileges", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		role, err := provider.CreateTestRole(ctx, conn, "")
+		c.Assert(err, qt.IsNil)
+
+		roleConn, err := role.Acquire(ctx, nil)
+		c.Assert(err, qt.IsNil)
+		defer roleConn.Release()
+
+		_, err = roleConn.Exec(ctx, "SELECT * FROM test_table")
+		var pgErr *pgconn.PgError
+		c.Assert(errors.As(err, &pgErr), qt.IsTrue)
+		c.Assert(pgErr.Code, qt.Equals, "42501") // insufficient_privilege
+	})
+
+	c.Run("Shutdown drops test roles", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		conn, _ := createTestDatabase(c, provider)
+
+		role, err := provider.CreateTestRole(ctx, conn, "")
+		c.Assert(err, qt.IsNil)
+		// Privileges in the database are dropped with the role.
+		_, err = conn.ExecContext(ctx, "GRANT SELECT ON test_table TO "+pgx.Identifier{role.Name()}.Sanitize())
+		c.Assert(err, qt.IsNil)
+		c.Assert(roleExists(c, conn, role.Name()), qt.IsTrue)
+
+		c.Assert(conn.Close(), qt.IsNil)
+		c.Assert(provider.Shutdown(ctx), qt.IsNil)
+
+		other := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer other.Close()
+		admin, err := other.Connect(ctx, "postgres")
+		c.Assert(err, qt.IsNil)
+		c.Assert(roleExists(c, admin, role.Name()), qt.IsFalse)
+
+		err = provider.DropTestRole(ctx, role.Name())
+		c.Assert(err, qt.ErrorMatches, `unknown test role ".*"`)
+	})
+
+	c.Run("Invalid template role", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := provider.CreateTestRole(ctx, conn, "no_such_role")
+		c.Assert(err, qt.ErrorMatches, `failed to grant role "no_such_role" to "test_role_\w+": .*does not exist.*`)
+
+		// The role is dropped again.
+		roleName := regexp.MustCompile(`test_role_\w+`).FindString(err.Error())
+		c.Assert(roleExists(c, conn, roleName), qt.IsFalse)
+	})
+
+	c.Run("Foreign connection", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+		other := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx)
+		defer func() { c.Assert(other.Shutdown(ctx), qt.IsNil) }()
+
+		conn, _ := createTestDatabase(c, other)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		_, err := provider.CreateTestRole(ctx, conn, "")
+		c.Assert(err, qt.ErrorMatches, "connection was not created by this provider")
+	})
+}
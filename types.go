@@ -0,0 +1,345 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WithTypes registers the named user-defined types, e.g. enums, composite
+// types, domains and ranges, together with their array types and the types
+// they depend on, on every connection the provider creates:
+//
+//	provider := pgdbtemplatepgx.NewConnectionProvider(connStringFunc,
+//		pgdbtemplatepgx.WithTypes("mood", "inventory.address"),
+//	)
+//
+// Types are looked up in each database when its first connection is
+// opened, and the definitions are cached until the provider closes its
+// last pool for the database. Types that do not exist in a database, e.g.
+// in the template database before migrations, are skipped, and looked up
+// again whenever a connection lacking them is acquired. Other user-defined
+// base types, such as citext, are registered to be read and written as text.
+//
+// Pools pinned to a schema, e.g. by SchemaManager, resolve names through
+// their search_path and cache their types separately. The admin pool is
+// skipped.
+func WithTypes(names ...string) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.typeNames = append(p.typeNames, names...)
+	}
+}
+
+// WithSchemaTypes registers all enums, composite types, domains and ranges
+// defined in the given schemas like WithTypes. Composite types of tables
+// are only registered if other registered types depend on them.
+func WithSchemaTypes(schemas ...string) ConnectionOption {
+	return func(p *ConnectionProvider) {
+		p.typeSchemas = append(p.typeSchemas, schemas...)
+	}
+}
+
+// userType describes a user-defined type as loaded from the catalog.
+type userType struct {
+	OID  uint32 `json:"oid"`
+	Name string `json:"name"`
+	// Kind is the typtype of the type, or "a" for arrays.
+	Kind string `json:"kind"`
+	// Element is the element type of arrays, the base type of domains
+	// and the subtype of ranges.
+	Element uint32          `json:"element"`
+	Fields  []userTypeField `json:"fields"`
+}
+
+// userTypeField describes an attribute of a composite type.
+type userTypeField struct {
+	Name string `json:"name"`
+	OID  uint32 `json:"oid"`
+}
+
+// typeCache holds the types loaded for a database.
+type typeCache struct {
+	mu     sync.Mutex
+	types  []userType
+	loaded bool
+}
+
+// userTypesQuery loads the requested types, their dependencies and the
+// arrays of both as JSON. Dependencies created by initdb, which have OIDs
+// below 16384, are left to pgx.
+const userTypesQuery = `
+	WITH RECURSIVE requested AS (
+		SELECT to_regtype(name)::oid AS oid, name
+		FROM json_array_elements_text($1::json) AS name
+	), selected(oid) AS (
+		SELECT oid FROM requested WHERE oid IS NOT NULL
+		UNION
+		SELECT t.oid
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class c ON c.oid = t.typrelid
+		WHERE n.nspname IN (SELECT json_array_elements_text($2::json))
+		  AND t.typtype IN ('c', 'd', 'e', 'r')
+		  AND (t.typtype <> 'c' OR c.relkind = 'c')
+	), dependencies(oid) AS (
+		SELECT oid FROM selected
+		UNION
+		SELECT dep.oid
+		FROM dependencies d
+		JOIN pg_type t ON t.oid = d.oid
+		CROSS JOIN LATERAL (
+			SELECT t.typbasetype WHERE t.typtype = 'd'
+			UNION ALL
+			SELECT t.typelem WHERE t.typcategory = 'A'
+			UNION ALL
+			SELECT r.rngsubtype FROM pg_range r WHERE r.rngtypid = t.oid
+			UNION ALL
+			SELECT a.atttypid FROM pg_attribute a
+			WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+		) AS dep(oid)
+		WHERE dep.oid >= 16384
+	)
+	SELECT json_build_object(
+		'missing', (SELECT coalesce(json_agg(name), '[]') FROM requested WHERE oid IS NULL),
+		'types', (
+			SELECT coalesce(json_agg(json_build_object(
+				'oid', t.oid,
+				'name', CASE WHEN pg_type_is_visible(t.oid) THEN quote_ident(t.typname)
+					ELSE quote_ident(n.nspname) || '.' || quote_ident(t.typname) END,
+				'kind', CASE WHEN t.typcategory = 'A' THEN 'a' ELSE t.typtype::text END,
+				'element', CASE
+					WHEN t.typtype = 'd' THEN t.typbasetype
+					WHEN t.typtype = 'r' THEN (SELECT rngsubtype FROM pg_range WHERE rngtypid = t.oid)
+					ELSE t.typelem END,
+				'fields', (
+					SELECT coalesce(json_agg(json_build_object('name', a.attname, 'oid', a.atttypid) ORDER BY a.attnum), '[]')
+					FROM pg_attribute a
+					WHERE a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+				)
+			) ORDER BY t.oid), '[]')
+			FROM pg_type t
+			JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE t.oid IN (SELECT oid FROM dependencies)
+			   OR t.oid IN (SELECT typarray FROM pg_type WHERE oid IN (SELECT oid FROM dependencies))
+		)
+	)`
+
+// registerPoolTypes chains hooks registering the configured types
+// on connections of the pool identified by key.
+func (p *ConnectionProvider) registerPoolTypes(config *pgxpool.Config, key poolKey) {
+	if (len(p.typeNames) == 0 && len(p.typeSchemas) == 0) || p.isAdminPool(key) {
+		return
+	}
+	// Replicas resolve types like their primary.
+	cacheKey := poolKey{database: key.database, schema: key.schema}
+
+	// incomplete holds the connections opened while some of the types
+	// did not exist yet, e.g. before migrations created them.
+	var incomplete sync.Map
+
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		// Types are registered first, so that they are available
+		// to user-provided hooks.
+		types, complete, err := p.poolTypes(ctx, conn, cacheKey)
+		if err != nil {
+			return err
+		}
+		if err := registerTypes(conn.TypeMap(), types); err != nil {
+			return err
+		}
+		if !complete {
+			incomplete.Store(conn, struct{}{})
+		}
+		if afterConnect != nil {
+			return afterConnect(ctx, conn)
+		}
+		return nil
+	}
+
+	beforeAcquire := config.BeforeAcquire
+	config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+		if beforeAcquire != nil && !beforeAcquire(ctx, conn) {
+			return false
+		}
+		if _, ok := incomplete.Load(conn); !ok {
+			return true
+		}
+
+		// Look the missing types up again, as they may have been
+		// created since. A failing connection is discarded by the pool.
+		types, complete, err := p.poolTypes(ctx, conn, cacheKey)
+		if err != nil || registerTypes(conn.TypeMap(), types) != nil {
+			return false
+		}
+		if complete {
+			incomplete.Delete(conn)
+		}
+		return true
+	}
+
+	beforeClose := config.BeforeClose
+	config.BeforeClose = func(conn *pgx.Conn) {
+		incomplete.Delete(conn)
+		if beforeClose != nil {
+			beforeClose(conn)
+		}
+	}
+}
+
+// poolTypes returns the types to register on connections of the pool
+// identified by key, loading them through conn unless they are cached,
+// and whether all configured types exist.
+func (p *ConnectionProvider) poolTypes(ctx context.Context, conn *pgx.Conn, key poolKey) ([]userType, bool, error) {
+	p.typeCachesMu.Lock()
+	cache, exists := p.typeCaches[key]
+	if !exists {
+		cache = &typeCache{}
+		p.typeCaches[key] = cache
+	}
+	p.typeCachesMu.Unlock()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.loaded {
+		return cache.types, true, nil
+	}
+
+	types, missing, err := p.loadTypes(ctx, conn)
+	if err != nil {
+		return nil, false, err
+	}
+	// Only complete lookups are cached, so that types created later are
+	// found by the next lookup. Finding no types at all counts as
+	// incomplete, as the schemas of WithSchemaTypes may be empty until
+	// migrations run.
+	if len(missing) == 0 && len(types) > 0 {
+		cache.types, cache.loaded = types, true
+	}
+	return types, cache.loaded, nil
+}
+
+// loadTypes loads the configured types from the catalog and returns them
+// along with the names of those that do not exist.
+func (p *ConnectionProvider) loadTypes(ctx context.Context, conn *pgx.Conn) ([]userType, []string, error) {
+	names, err := json.Marshal(append([]string{}, p.typeNames...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode type names: %w", err)
+	}
+	schemas, err := json.Marshal(append([]string{}, p.typeSchemas...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode schema names: %w", err)
+	}
+
+	results, err := execUntraced(ctx, conn, userTypesQuery, names, schemas)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load types: %w", err)
+	}
+
+	var loaded struct {
+		Missing []string   `json:"missing"`
+		Types   []userType `json:"types"`
+	}
+	if err := json.Unmarshal(results[0].Rows[0][0], &loaded); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode types: %w", err)
+	}
+	return loaded.Types, loaded.Missing, nil
+}
+
+// registerTypes registers types on m, registering the types
+// that others depend on first.
+func registerTypes(m *pgtype.Map, types []userType) error {
+	pending := make(map[uint32]struct{}, len(types))
+	for _, t := range types {
+		pending[t.OID] = struct{}{}
+	}
+
+	// dependency returns the registered type of oid and whether it
+	// is ready. Types unknown to pgx are read and written as text.
+	dependency := func(oid uint32) (*pgtype.Type, bool) {
+		if dt, ok := m.TypeForOID(oid); ok {
+			return dt, true
+		}
+		if _, ok := pending[oid]; ok {
+			return nil, false
+		}
+		return &pgtype.Type{Name: fmt.Sprint(oid), OID: oid, Codec: pgtype.TextCodec{}}, true
+	}
+
+	for len(pending) > 0 {
+		progress := false
+		for _, t := range types {
+			if _, ok := pending[t.OID]; !ok {
+				continue
+			}
+			codec, ready := t.codec(dependency)
+			if !ready {
+				continue
+			}
+			m.RegisterType(&pgtype.Type{Name: t.Name, OID: t.OID, Codec: codec})
+			delete(pending, t.OID)
+			progress = true
+		}
+		if !progress {
+			var names []string
+			for _, t := range types {
+				if _, ok := pending[t.OID]; ok {
+					names = append(names, t.Name)
+				}
+			}
+			return fmt.Errorf("failed to register types with circular dependencies: %s", strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// codec returns a new codec for t and true, or false if a type
+// t depends on is not ready yet.
+func (t userType) codec(dependency func(oid uint32) (*pgtype.Type, bool)) (pgtype.Codec, bool) {
+	switch t.Kind {
+	case "e":
+		return &pgtype.EnumCodec{}, true
+	case "c":
+		fields := make([]pgtype.CompositeCodecField, len(t.Fields))
+		for i, f := range t.Fields {
+			dt, ready := dependency(f.OID)
+			if !ready {
+				return nil, false
+			}
+			fields[i] = pgtype.CompositeCodecField{Name: f.Name, Type: dt}
+		}
+		return &pgtype.CompositeCodec{Fields: fields}, true
+	case "a", "d", "r":
+		element, ready := dependency(t.Element)
+		if !ready {
+			return nil, false
+		}
+		switch t.Kind {
+		case "a":
+			return &pgtype.ArrayCodec{ElementType: element}, true
+		case "d":
+			return element.Codec, true
+		default:
+			return &pgtype.RangeCodec{ElementType: element}, true
+		}
+	default:
+		return pgtype.TextCodec{}, true
+	}
+}
+
+// forgetTypes drops the cached types of all pools of dbName.
+func (p *ConnectionProvider) forgetTypes(dbName string) {
+	p.typeCachesMu.Lock()
+	defer p.typeCachesMu.Unlock()
+	for key := range p.typeCaches {
+		if key.database == dbName {
+			delete(p.typeCaches, key)
+		}
+	}
+}
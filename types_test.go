@@ -0,0 +1,178 @@
+package pgdbtemplatepgx_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/andrei-polukhin/pgdbtemplate"
+	pgdbtemplatepgx "github.com/andrei-polukhin/pgdbtemplate-pgx"
+	"github.com/andrei-polukhin/pgdbtemplate-pgx/pgdbtemplatepgxtest"
+)
+
+// typesMigration defines the user-defined types of the tests.
+const typesMigration = `
+	CREATE TYPE mood AS ENUM ('sad', 'ok', 'happy');
+	CREATE DOMAIN rating AS INT CHECK (VALUE BETWEEN 1 AND 5);
+	CREATE SCHEMA inventory;
+	CREATE TYPE inventory.item AS (name TEXT, moods mood[], rating rating);
+	CREATE TYPE inventory.size AS ENUM ('s', 'm', 'l');
+	CREATE TABLE inventory.stock (id INT);
+`
+
+// createTypedDatabase creates a test database with the user-defined types
+// of typesMigration through provider.
+func createTypedDatabase(c *qt.C, provider *pgdbtemplatepgx.ConnectionProvider) pgdbtemplate.DatabaseConnection {
+	ctx := context.Background()
+	migrations := writeMigrations(c, map[string]string{"001_types.sql": typesMigration})
+
+	tm := newTestTemplateManager(c, pgdbtemplatepgx.NewMigrationRunner([]string{migrations}, nil), withProvider(provider))
+	c.Assert(tm.Initialize(ctx), qt.IsNil)
+
+	conn, _, err := tm.CreateTestDatabase(ctx)
+	c.Assert(err, qt.IsNil)
+	return conn
+}
+
+// registeredTypes reports which of names are registered
+// on a connection of conn's pool.
+func registeredTypes(c *qt.C, conn pgdbtemplate.DatabaseConnection, names ...string) map[string]bool {
+	poolConn, err := conn.(*pgdbtemplatepgx.DatabaseConnection).Pool.Acquire(context.Background())
+	c.Assert(err, qt.IsNil)
+	defer poolConn.Release()
+
+	registered := make(map[string]bool)
+	for _, name := range names {
+		_, registered[name] = poolConn.Conn().TypeMap().TypeForName(name)
+	}
+	return registered
+}
+
+func TestTypes(t *testing.T) {
+	t.Parallel()
+	c := qt.New(t)
+	ctx := context.Background()
+
+	type item struct {
+		Name   string
+		Moods  []string
+		Rating int
+	}
+
+	c.Run("Register named types and dependencies", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithTypes("inventory.item"),
+			pgdbtemplatepgx.WithQueryCapture(),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn := createTypedDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		c.Assert(registeredTypes(c, conn, "mood", "_mood", "rating", "inventory.item", "inventory._item", "inventory.size"),
+			qt.DeepEquals, map[string]bool{
+				"mood": true, "_mood": true, "rating": true,
+				"inventory.item": true, "inventory._item": true,
+				"inventory.size": false,
+			})
+
+		var got []item
+		err := conn.QueryRowContext(ctx,
+			"SELECT ARRAY[ROW('lamp', ARRAY['happy', 'ok']::mood[], 4)::inventory.item]").Scan(&got)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []item{{Name: "lamp", Moods: []string{"happy", "ok"}, Rating: 4}})
+
+		var moods []string
+		err = conn.QueryRowContext(ctx, "SELECT $1::mood[]", []string{"sad"}).Scan(&moods)
+		c.Assert(err, qt.IsNil)
+		c.Assert(moods, qt.DeepEquals, []string{"sad"})
+
+		// Loading types does not show up in captured queries.
+		pgdbtemplatepgxtest.AssertNoQueryMatching(c.TB, conn, `pg_type`)
+	})
+
+	c.Run("Register all types of a schema", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithSchemaTypes("inventory"),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn := createTypedDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		c.Assert(registeredTypes(c, conn, "inventory.item", "inventory.size", "inventory._size", "mood", "inventory.stock"),
+			qt.DeepEquals, map[string]bool{
+				"inventory.item": true, "inventory.size": true, "inventory._size": true, "mood": true,
+				"inventory.stock": false,
+			})
+
+		var sizes []string
+		err := conn.QueryRowContext(ctx, "SELECT ARRAY['s', 'l']::inventory.size[]").Scan(&sizes)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sizes, qt.DeepEquals, []string{"s", "l"})
+	})
+
+	c.Run("Register types of test schemas", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithTypes("mood"),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		migrations := writeMigrations(c, map[string]string{
+			"001_mood.sql": "CREATE TYPE mood AS ENUM ('sad', 'ok', 'happy');",
+		})
+		sm, err := pgdbtemplatepgx.NewSchemaManager(pgdbtemplatepgx.SchemaConfig{
+			ConnectionProvider: provider,
+			MigrationRunner:    pgdbtemplatepgx.NewMigrationRunner([]string{migrations}, nil),
+			TestSchemaPrefix:   fmt.Sprintf("pgx_types_%d_%d_", time.Now().UnixNano(), os.Getpid()),
+		})
+		c.Assert(err, qt.IsNil)
+		defer func() { c.Assert(sm.Cleanup(ctx), qt.IsNil) }()
+
+		// Every schema has its own mood type.
+		for i := 0; i < 2; i++ {
+			conn, _, err := sm.CreateTestSchema(ctx)
+			c.Assert(err, qt.IsNil)
+			defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+			c.Assert(registeredTypes(c, conn, "mood"), qt.DeepEquals, map[string]bool{"mood": true})
+			var moods []string
+			c.Assert(conn.QueryRowContext(ctx, "SELECT ARRAY['ok']::mood[]").Scan(&moods), qt.IsNil)
+			c.Assert(moods, qt.DeepEquals, []string{"ok"})
+		}
+	})
+
+	c.Run("Types are registered on every connection", func(c *qt.C) {
+		c.Parallel()
+		provider := pgdbtemplatepgx.NewConnectionProvider(testConnectionStringFuncPgx,
+			pgdbtemplatepgx.WithTypes("mood", "no_such_type"),
+			pgdbtemplatepgx.WithMaxConns(3),
+		)
+		defer func() { c.Assert(provider.Shutdown(ctx), qt.IsNil) }()
+
+		conn := createTypedDatabase(c, provider)
+		defer func() { c.Assert(conn.Close(), qt.IsNil) }()
+
+		pool := conn.(*pgdbtemplatepgx.DatabaseConnection).Pool
+		var conns []*pgx.Conn
+		for i := 0; i < 3; i++ {
+			poolConn, err := pool.Acquire(ctx)
+			c.Assert(err, qt.IsNil)
+			defer poolConn.Release()
+			conns = append(conns, poolConn.Conn())
+		}
+		for _, pgxConn := range conns {
+			var moods []string
+			c.Assert(pgxConn.QueryRow(ctx, "SELECT ARRAY['ok']::mood[]").Scan(&moods), qt.IsNil)
+			c.Assert(moods, qt.DeepEquals, []string{"ok"})
+		}
+	})
+}
@@ -0,0 +1,22 @@
+package pgdbtemplatepgx
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// execUntraced runs sql on conn through pgconn and returns its results.
+// sql may hold several statements unless args are given.
+//
+// pgconn bypasses the query tracers of conn, so statements the provider
+// runs on its own behalf, e.g. to change session settings, do not show up
+// in captured queries or slow query reports.
+func execUntraced(ctx context.Context, conn *pgx.Conn, sql string, args ...[]byte) ([]*pgconn.Result, error) {
+	if len(args) > 0 {
+		result := conn.PgConn().ExecParams(ctx, sql, args, nil, nil, nil).Read()
+		return []*pgconn.Result{result}, result.Err
+	}
+	return conn.PgConn().Exec(ctx, sql).ReadAll()
+}